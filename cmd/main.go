@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mahdiXak47/Download-Manager/internal/auth"
 	"github.com/mahdiXak47/Download-Manager/internal/logger"
 	"github.com/mahdiXak47/Download-Manager/internal/tui"
 )
@@ -21,6 +22,10 @@ func main() {
 		fmt.Printf("Warning: Could not initialize logger: %v\n", err)
 	}
 
+	if err := auth.Load(); err != nil {
+		fmt.Printf("Warning: Could not load credentials: %v\n", err)
+	}
+
 	p := tea.NewProgram(tui.NewModel(),
 		tea.WithAltScreen(),
 		tea.WithMouseCellMotion(),
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Credential types
+const (
+	TypeBasic  = "basic"
+	TypeDigest = "digest"
+	TypeBearer = "bearer"
+)
+
+const credentialsFileName = "credentials.json"
+
+// Credential holds the secret used for one host, optionally limited to a path prefix
+type Credential struct {
+	Host       string `json:"host"`                  // host name, with port if it is not the default
+	PathPrefix string `json:"path_prefix,omitempty"` // only URLs below this path, empty for the whole host
+	Type       string `json:"type"`                  // basic, digest or bearer
+	Username   string `json:"username,omitempty"`
+	Password   string `json:"password,omitempty"`
+	Token      string `json:"token,omitempty"`
+}
+
+// Store is the set of credentials kept outside the shareable download configuration
+type Store struct {
+	Credentials []Credential `json:"credentials"`
+}
+
+var (
+	store = &Store{}
+	mu    sync.RWMutex
+
+	// digest challenges already answered, so later requests can authenticate up front
+	challenges = make(map[string]*digestChallenge)
+)
+
+// GetCredentialsPath returns the path to the credentials file, next to the config file
+func GetCredentialsPath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "."
+	}
+	return filepath.Join(homeDir, ".config", "download-manager", credentialsFileName)
+}
+
+// Load reads the credentials file, if any, and tightens its permissions to owner-only
+func Load() error {
+	path := GetCredentialsPath()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0077 != 0 {
+		if err := os.Chmod(path, 0600); err != nil {
+			return fmt.Errorf("credentials file %s is readable by others: %w", path, err)
+		}
+	}
+
+	var loaded Store
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("failed to parse credentials file: %w", err)
+	}
+	for i := range loaded.Credentials {
+		loaded.Credentials[i].Type = strings.ToLower(loaded.Credentials[i].Type)
+	}
+
+	mu.Lock()
+	store = &loaded
+	challenges = make(map[string]*digestChallenge)
+	mu.Unlock()
+	return nil
+}
+
+// Count returns the number of stored credentials
+func Count() int {
+	mu.RLock()
+	defer mu.RUnlock()
+	return len(store.Credentials)
+}
+
+// Lookup returns the credential for a URL, preferring the longest matching path prefix
+func Lookup(u *url.URL) *Credential {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var best *Credential
+	for i := range store.Credentials {
+		c := &store.Credentials[i]
+		if !hostMatches(c.Host, u) || !strings.HasPrefix(u.Path, c.PathPrefix) {
+			continue
+		}
+		if best == nil || len(c.PathPrefix) > len(best.PathPrefix) {
+			best = c
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	found := *best
+	return &found
+}
+
+// hostMatches compares a configured host with a URL, with or without the port
+func hostMatches(host string, u *url.URL) bool {
+	return strings.EqualFold(host, u.Host) || strings.EqualFold(host, u.Hostname())
+}
+
+// Apply adds the credential for the request URL to req. Digest credentials can only
+// be sent once the server's challenge is known, so they are applied after a 401 via
+// Challenge, or up front when an earlier challenge for the host is cached.
+func Apply(req *http.Request) {
+	if req.Header.Get("Authorization") != "" {
+		return
+	}
+	c := Lookup(req.URL)
+	if c == nil {
+		return
+	}
+
+	switch c.Type {
+	case TypeBasic:
+		req.SetBasicAuth(c.Username, c.Password)
+	case TypeBearer:
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	case TypeDigest:
+		mu.Lock()
+		challenge := challenges[req.URL.Host]
+		mu.Unlock()
+		if challenge != nil {
+			req.Header.Set("Authorization", challenge.authorize(c, req))
+		}
+	}
+}
+
+// Challenge answers a 401 response carrying a Digest challenge. It returns true when
+// req has been given an Authorization header and should be sent again; callers retry
+// only once so wrong credentials don't loop.
+func Challenge(req *http.Request, resp *http.Response) bool {
+	if resp.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+	c := Lookup(req.URL)
+	if c == nil || c.Type != TypeDigest {
+		return false
+	}
+
+	challenge, err := parseDigestChallenge(resp.Header.Values("WWW-Authenticate"))
+	if err != nil {
+		return false
+	}
+
+	mu.Lock()
+	challenges[req.URL.Host] = challenge
+	mu.Unlock()
+
+	req.Header.Set("Authorization", challenge.authorize(c, req))
+	return true
+}
@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// digestChallenge is a parsed "WWW-Authenticate: Digest ..." header (RFC 7616)
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string
+	stale     bool
+
+	mu         sync.Mutex
+	nonceCount int
+}
+
+// parseDigestChallenge picks the Digest challenge among the WWW-Authenticate headers
+func parseDigestChallenge(headers []string) (*digestChallenge, error) {
+	for _, header := range headers {
+		scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+		if !strings.EqualFold(scheme, "Digest") {
+			continue
+		}
+
+		params := parseAuthParams(rest)
+		c := &digestChallenge{
+			realm:     params["realm"],
+			nonce:     params["nonce"],
+			opaque:    params["opaque"],
+			algorithm: strings.ToUpper(params["algorithm"]),
+			stale:     strings.EqualFold(params["stale"], "true"),
+		}
+		if c.algorithm == "" {
+			c.algorithm = "MD5"
+		}
+		if c.nonce == "" {
+			return nil, errors.New("digest challenge without nonce")
+		}
+		if c.newHash() == nil {
+			return nil, fmt.Errorf("unsupported digest algorithm %s", c.algorithm)
+		}
+		// Prefer "auth"; "auth-int" would need the request body
+		for _, qop := range strings.Split(params["qop"], ",") {
+			if strings.TrimSpace(qop) == "auth" {
+				c.qop = "auth"
+			}
+		}
+		return c, nil
+	}
+	return nil, errors.New("no digest challenge")
+}
+
+// parseAuthParams splits `a="x, y", b=z` into its parameters
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		name, rest, found := strings.Cut(s, "=")
+		if !found {
+			break
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		rest = strings.TrimSpace(rest)
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			// Quoted string, with backslash escapes
+			var b strings.Builder
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				b.WriteByte(rest[i])
+			}
+			value = b.String()
+			rest = rest[min(i+1, len(rest)):]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+			value = strings.TrimSpace(value)
+			rest = "," + rest
+		}
+		params[name] = value
+
+		_, s, _ = strings.Cut(rest, ",")
+	}
+	return params
+}
+
+// newHash returns the hash function named by the challenge, or nil if unsupported
+func (c *digestChallenge) newHash() hash.Hash {
+	switch strings.TrimSuffix(c.algorithm, "-SESS") {
+	case "MD5":
+		return md5.New()
+	case "SHA-256":
+		return sha256.New()
+	}
+	return nil
+}
+
+// hashHex hashes the parts joined by colons
+func (c *digestChallenge) hashHex(parts ...string) string {
+	h := c.newHash()
+	h.Write([]byte(strings.Join(parts, ":")))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// authorize builds the Authorization header answering the challenge for req
+func (c *digestChallenge) authorize(cred *Credential, req *http.Request) string {
+	c.mu.Lock()
+	c.nonceCount++
+	nc := fmt.Sprintf("%08x", c.nonceCount)
+	c.mu.Unlock()
+
+	cnonceBytes := make([]byte, 8)
+	rand.Read(cnonceBytes)
+	cnonce := hex.EncodeToString(cnonceBytes)
+
+	uri := req.URL.RequestURI()
+	ha1 := c.hashHex(cred.Username, c.realm, cred.Password)
+	if strings.HasSuffix(c.algorithm, "-SESS") {
+		ha1 = c.hashHex(ha1, c.nonce, cnonce)
+	}
+	ha2 := c.hashHex(req.Method, uri)
+
+	var response string
+	if c.qop != "" {
+		response = c.hashHex(ha1, c.nonce, nc, cnonce, c.qop, ha2)
+	} else {
+		response = c.hashHex(ha1, c.nonce, ha2)
+	}
+
+	fields := []string{
+		fmt.Sprintf(`username="%s"`, cred.Username),
+		fmt.Sprintf(`realm="%s"`, c.realm),
+		fmt.Sprintf(`nonce="%s"`, c.nonce),
+		fmt.Sprintf(`uri="%s"`, uri),
+		fmt.Sprintf(`algorithm=%s`, c.algorithm),
+		fmt.Sprintf(`response="%s"`, response),
+	}
+	if c.qop != "" {
+		fields = append(fields, "qop="+c.qop, "nc="+nc, fmt.Sprintf(`cnonce="%s"`, cnonce))
+	}
+	if c.opaque != "" {
+		fields = append(fields, fmt.Sprintf(`opaque="%s"`, c.opaque))
+	}
+	return "Digest " + strings.Join(fields, ", ")
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const monthFormat = "2006-01"
+
+// QuotaUsage counts the bytes a queue has downloaded in the current day and month.
+// A count belongs to the period it names and starts over when a new one begins.
+type QuotaUsage struct {
+	Day        string `json:"day,omitempty"` // Format: "YYYY-MM-DD"
+	DayBytes   int64  `json:"day_bytes,omitempty"`
+	Month      string `json:"month,omitempty"` // Format: "YYYY-MM"
+	MonthBytes int64  `json:"month_bytes,omitempty"`
+}
+
+// at returns the counts for the periods containing now
+func (u QuotaUsage) at(now time.Time) QuotaUsage {
+	if day := now.Format(dateFormat); u.Day != day {
+		u.Day, u.DayBytes = day, 0
+	}
+	if month := now.Format(monthFormat); u.Month != month {
+		u.Month, u.MonthBytes = month, 0
+	}
+	return u
+}
+
+// AddUsage counts n downloaded bytes against the queue's quotas
+func (q *QueueConfig) AddUsage(n int64, now time.Time) {
+	q.Usage = q.Usage.at(now)
+	q.Usage.DayBytes += n
+	q.Usage.MonthBytes += n
+}
+
+// UsageAt returns the bytes the queue has downloaded in the day and month containing now
+func (q *QueueConfig) UsageAt(now time.Time) QuotaUsage {
+	return q.Usage.at(now)
+}
+
+// QuotaExceeded returns why the queue may not download more at now, or "" if it is
+// within its quotas
+func (q *QueueConfig) QuotaExceeded(now time.Time) string {
+	usage := q.UsageAt(now)
+	if q.MonthlyQuota > 0 && usage.MonthBytes >= q.MonthlyQuota {
+		return fmt.Sprintf("monthly quota used (%s of %s)", FormatByteSize(usage.MonthBytes), FormatByteSize(q.MonthlyQuota))
+	}
+	if q.DailyQuota > 0 && usage.DayBytes >= q.DailyQuota {
+		return fmt.Sprintf("daily quota used (%s of %s)", FormatByteSize(usage.DayBytes), FormatByteSize(q.DailyQuota))
+	}
+	return ""
+}
+
+// QuotaResetAt returns when the quota the queue has used up starts over: midnight for
+// the daily quota, the first of next month for the monthly one. It returns false if
+// no quota is used up.
+func (q *QueueConfig) QuotaResetAt(now time.Time) (time.Time, bool) {
+	usage := q.UsageAt(now)
+	if q.MonthlyQuota > 0 && usage.MonthBytes >= q.MonthlyQuota {
+		year, month, _ := now.Date()
+		return time.Date(year, month+1, 1, 0, 0, 0, 0, now.Location()), true
+	}
+	if q.DailyQuota > 0 && usage.DayBytes >= q.DailyQuota {
+		return startOfDay(now).AddDate(0, 0, 1), true
+	}
+	return time.Time{}, false
+}
+
+var byteSizeUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+}
+
+// ParseByteSize parses sizes such as "500M", "10G" or "1.5T" into bytes. A plain
+// number is taken as bytes and an optional trailing "B" is ignored.
+func ParseByteSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+	number, multiplier := strings.TrimSuffix(s, "B"), int64(1)
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number, multiplier = strings.TrimSuffix(number, unit.suffix), unit.size
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q, expected a number with an optional K, M, G or T suffix", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// FormatByteSize writes a size in the form read by ParseByteSize, to one decimal
+// place unless it is a whole number of units
+func FormatByteSize(n int64) string {
+	for _, unit := range byteSizeUnits {
+		if n >= unit.size {
+			if n%unit.size == 0 {
+				return fmt.Sprintf("%d%s", n/unit.size, unit.suffix)
+			}
+			return fmt.Sprintf("%.1f%s", float64(n)/float64(unit.size), unit.suffix)
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
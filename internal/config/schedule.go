@@ -0,0 +1,239 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const dateFormat = "2006-01-02"
+
+// scheduleHorizon bounds how far ahead NextStart looks for an allowed time
+const scheduleHorizon = 400 * 24 * time.Hour
+
+// ScheduleWindow is a daily time window on selected days of the week. A window whose
+// end is before its start runs past midnight and belongs to the day it starts on.
+type ScheduleWindow struct {
+	Days      []string `json:"days,omitempty"` // "mon".."sun", "weekdays", "weekends", empty for every day
+	StartTime string   `json:"start_time"`     // Format: "HH:MM"
+	EndTime   string   `json:"end_time"`       // Format: "HH:MM", inclusive
+}
+
+// ScheduleException overrides the windows for whole days in a date range
+type ScheduleException struct {
+	From    string `json:"from"`    // Format: "YYYY-MM-DD"
+	To      string `json:"to"`      // Format: "YYYY-MM-DD", inclusive
+	Allowed bool   `json:"allowed"` // true to run all day, false to not run at all
+}
+
+// Schedule decides when a queue may start and keep running downloads
+type Schedule struct {
+	Windows    []ScheduleWindow
+	Exceptions []ScheduleException
+}
+
+var weekdayNames = map[string][]time.Weekday{
+	"sun":      {time.Sunday},
+	"mon":      {time.Monday},
+	"tue":      {time.Tuesday},
+	"wed":      {time.Wednesday},
+	"thu":      {time.Thursday},
+	"fri":      {time.Friday},
+	"sat":      {time.Saturday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekends": {time.Saturday, time.Sunday},
+}
+
+// Schedule returns the queue's schedule. Queues without Windows use the single
+// StartTime-EndTime window on every day.
+func (q *QueueConfig) Schedule() Schedule {
+	windows := q.Windows
+	if len(windows) == 0 {
+		windows = []ScheduleWindow{{StartTime: q.StartTime, EndTime: q.EndTime}}
+	}
+	return Schedule{Windows: windows, Exceptions: q.Exceptions}
+}
+
+// AllowedAt checks if the queue is enabled and its schedule allows downloads at t
+func (q *QueueConfig) AllowedAt(t time.Time) bool {
+	return q.Enabled && q.Schedule().Allowed(t)
+}
+
+// NextAllowedStart returns when the queue's schedule next allows downloads, which is
+// t itself if they are allowed now. It returns false if nothing is allowed within a year.
+func (q *QueueConfig) NextAllowedStart(t time.Time) (time.Time, bool) {
+	return q.Schedule().NextStart(t)
+}
+
+// Allowed checks if the schedule allows downloads at t
+func (s Schedule) Allowed(t time.Time) bool {
+	if allowed, found := s.exceptionOn(t); found {
+		return allowed
+	}
+
+	currentTime := t.Format("15:04")
+	yesterday := t.AddDate(0, 0, -1).Weekday()
+	for _, w := range s.Windows {
+		overnight := w.StartTime > w.EndTime
+		// Started today
+		if w.onDay(t.Weekday()) && currentTime >= w.StartTime && (overnight || currentTime <= w.EndTime) {
+			return true
+		}
+		// Started yesterday and runs past midnight
+		if overnight && w.onDay(yesterday) && currentTime <= w.EndTime {
+			return true
+		}
+	}
+	return false
+}
+
+// NextStart returns the first time at or after t when downloads are allowed
+func (s Schedule) NextStart(t time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute)
+	if s.Allowed(t) {
+		return t, true
+	}
+
+	// Allowed periods can only begin at midnight (where exceptions begin and end) or at a window start
+	for day := startOfDay(t); day.Sub(t) < scheduleHorizon; day = day.AddDate(0, 0, 1) {
+		candidates := []time.Time{day}
+		for _, w := range s.Windows {
+			if w.onDay(day.Weekday()) {
+				candidates = append(candidates, clockTime(day, w.StartTime))
+			}
+		}
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+
+		for _, c := range candidates {
+			if c.After(t) && s.Allowed(c) {
+				return c, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// NextChange returns the first time after t when the schedule switches between
+// allowing and not allowing downloads
+func (s Schedule) NextChange(t time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute)
+	allowed := s.Allowed(t)
+
+	// Changes happen at midnight (where exceptions begin and end), at a window start,
+	// or the minute after a window's inclusive end
+	for day := startOfDay(t); day.Sub(t) < scheduleHorizon; day = day.AddDate(0, 0, 1) {
+		candidates := []time.Time{day}
+		for _, w := range s.Windows {
+			candidates = append(candidates, clockTime(day, w.StartTime), clockTime(day, w.EndTime).Add(time.Minute))
+		}
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+
+		for _, c := range candidates {
+			if c.After(t) && s.Allowed(c) != allowed {
+				return c, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// clockTime returns the "HH:MM" time on day, or day itself if the time is invalid
+func clockTime(day time.Time, hhmm string) time.Time {
+	clock, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return day
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, day.Location())
+}
+
+// exceptionOn returns the exception covering t's date, if any
+func (s Schedule) exceptionOn(t time.Time) (allowed bool, found bool) {
+	date := t.Format(dateFormat)
+	for _, e := range s.Exceptions {
+		if date >= e.From && date <= e.To {
+			return e.Allowed, true
+		}
+	}
+	return false, false
+}
+
+// onDay checks if the window applies to windows starting on the given weekday
+func (w ScheduleWindow) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, name := range w.Days {
+		for _, d := range weekdayNames[strings.ToLower(name)] {
+			if d == day {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// startOfDay returns midnight at the start of t's day
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// ParseScheduleWindows parses windows written as "weekdays 23:00-06:00; sat,sun 00:00-23:59".
+// The days may be left out for a window that applies every day.
+func ParseScheduleWindows(s string) ([]ScheduleWindow, error) {
+	var windows []ScheduleWindow
+	for _, field := range strings.Split(s, ";") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		var window ScheduleWindow
+		timeRange := field
+		if days, rest, found := strings.Cut(field, " "); found {
+			timeRange = strings.TrimSpace(rest)
+			for _, day := range strings.Split(days, ",") {
+				day = strings.ToLower(strings.TrimSpace(day))
+				if _, ok := weekdayNames[day]; !ok {
+					return nil, fmt.Errorf("unknown day %q in window %q, expected mon..sun, weekdays or weekends", day, field)
+				}
+				window.Days = append(window.Days, day)
+			}
+		}
+
+		start, end, found := strings.Cut(timeRange, "-")
+		if !found {
+			return nil, fmt.Errorf("invalid window %q, expected [days] HH:MM-HH:MM", field)
+		}
+		window.StartTime, window.EndTime = strings.TrimSpace(start), strings.TrimSpace(end)
+		for _, t := range []string{window.StartTime, window.EndTime} {
+			if _, err := time.Parse("15:04", t); err != nil || len(t) != 5 {
+				return nil, fmt.Errorf("invalid time %q in window %q", t, field)
+			}
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// FormatScheduleWindows writes windows in the form read by ParseScheduleWindows
+func FormatScheduleWindows(windows []ScheduleWindow) string {
+	fields := make([]string, len(windows))
+	for i, w := range windows {
+		fields[i] = fmt.Sprintf("%s-%s", w.StartTime, w.EndTime)
+		if len(w.Days) > 0 {
+			fields[i] = strings.Join(w.Days, ",") + " " + fields[i]
+		}
+	}
+	return strings.Join(fields, "; ")
+}
+
+// String describes the schedule for log messages
+func (s Schedule) String() string {
+	description := FormatScheduleWindows(s.Windows)
+	if len(s.Exceptions) > 0 {
+		description += fmt.Sprintf(" (%d date exceptions)", len(s.Exceptions))
+	}
+	return description
+}
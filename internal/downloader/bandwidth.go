@@ -0,0 +1,110 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// Bandwidth is limited at three levels: a global cap shared by every download, a cap
+// per queue shared by the queue's active downloads, and an optional cap per download
+// (MaxBandwidth). Each chunk read draws from all of them, so the tightest level wins,
+// and bandwidth left unused by paused or finished downloads goes to the others.
+var (
+	sharedLimitsMutex sync.Mutex
+	globalLimiter     *RateLimiter
+	queueLimiters     = make(map[string]*RateLimiter)
+)
+
+// SetGlobalBandwidth sets the cap shared by all downloads in KB/s, 0 for unlimited
+func SetGlobalBandwidth(kbps int64) {
+	sharedLimitsMutex.Lock()
+	defer sharedLimitsMutex.Unlock()
+	globalLimiter = replaceLimiter(globalLimiter, kbps)
+}
+
+// SetQueueBandwidth sets the cap shared by a queue's downloads in KB/s, 0 for unlimited
+func SetQueueBandwidth(queue string, kbps int64) {
+	sharedLimitsMutex.Lock()
+	defer sharedLimitsMutex.Unlock()
+	if limiter := replaceLimiter(queueLimiters[queue], kbps); limiter != nil {
+		queueLimiters[queue] = limiter
+	} else {
+		delete(queueLimiters, queue)
+	}
+}
+
+// replaceLimiter returns the limiter for a new rate: current with its rate changed in
+// place, a new limiter if there was none, or nil (releasing waiters) for unlimited
+func replaceLimiter(current *RateLimiter, kbps int64) *RateLimiter {
+	switch {
+	case kbps <= 0:
+		if current != nil {
+			current.Stop()
+		}
+		return nil
+	case current != nil:
+		current.SetRate(kbps * 1024)
+		return current
+	default:
+		return NewRateLimiter(kbps * 1024)
+	}
+}
+
+// SetBandwidth changes the download's own limit in KB/s, 0 for unlimited. It takes
+// effect immediately if the download is running.
+func (d *Download) SetBandwidth(kbps int64) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if kbps < 0 {
+		kbps = 0
+	}
+	d.MaxBandwidth = kbps
+	d.limiter = replaceLimiter(d.limiter, kbps)
+}
+
+// ownLimiter returns the download's own limiter, creating it from MaxBandwidth on first use
+func (d *Download) ownLimiter() *RateLimiter {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.limiter == nil && d.MaxBandwidth > 0 {
+		d.limiter = NewRateLimiter(d.MaxBandwidth * 1024) // Convert KB/s to bytes/s
+	}
+	return d.limiter
+}
+
+// throttle reads on behalf of one download, drawing from its own limiter and the
+// shared queue and global limiters
+type throttle struct {
+	ctx context.Context // cuts a wait short when the download is paused or cancelled
+	d   *Download
+}
+
+// newThrottle creates the throttle for a download
+func (d *Download) newThrottle(ctx context.Context) *throttle {
+	return &throttle{ctx: ctx, d: d}
+}
+
+// Read reads from reader and waits until every level has allowed the bytes read
+func (t *throttle) Read(reader io.Reader, buffer []byte) (int, error) {
+	n, err := reader.Read(buffer)
+	if n > 0 {
+		// Limiters are looked up on every read so changed limits apply right away
+		own := t.d.ownLimiter()
+		sharedLimitsMutex.Lock()
+		queue, global := queueLimiters[t.d.Queue], globalLimiter
+		sharedLimitsMutex.Unlock()
+
+		for _, limiter := range []*RateLimiter{own, queue, global} {
+			if limiter == nil {
+				continue
+			}
+			if waitErr := limiter.Wait(t.ctx, int64(n)); waitErr != nil && err == nil {
+				return n, waitErr
+			}
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,210 @@
+package downloader
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/mahdiXak47/Download-Manager/internal/logger"
+)
+
+// ErrVerifyFailed is returned when a completed download does not match its expected checksum
+var ErrVerifyFailed = errors.New("checksum verification failed")
+
+// newHasher returns a hash for one of the supported algorithms: md5, sha1, sha256, sha512
+func newHasher(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm: %s", algorithm)
+}
+
+// normalizeAlgorithm maps spellings like "SHA-256" to the names used by newHasher
+func normalizeAlgorithm(algorithm string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(algorithm)), "-", "")
+}
+
+// algorithmForLength guesses the algorithm of a bare hex digest from its length
+func algorithmForLength(length int) string {
+	switch length {
+	case 32:
+		return "md5"
+	case 40:
+		return "sha1"
+	case 64:
+		return "sha256"
+	case 128:
+		return "sha512"
+	}
+	return ""
+}
+
+// ParseChecksum parses "sha256:<hex>", "sha256=<hex>" or a bare hex digest
+func ParseChecksum(spec string) (algorithm, digest string, err error) {
+	spec = strings.TrimSpace(spec)
+	if i := strings.IndexAny(spec, ":="); i >= 0 {
+		algorithm = normalizeAlgorithm(spec[:i])
+		digest = strings.ToLower(strings.TrimSpace(spec[i+1:]))
+	} else {
+		digest = strings.ToLower(spec)
+		algorithm = algorithmForLength(len(digest))
+	}
+
+	if _, err := newHasher(algorithm); err != nil {
+		return "", "", fmt.Errorf("cannot tell checksum algorithm of %q", spec)
+	}
+	if _, err := hex.DecodeString(digest); err != nil || len(digest) != 2*hasherSize(algorithm) {
+		return "", "", fmt.Errorf("invalid %s digest %q", algorithm, digest)
+	}
+	return algorithm, digest, nil
+}
+
+// hasherSize returns the digest length in bytes of a supported algorithm
+func hasherSize(algorithm string) int {
+	h, err := newHasher(algorithm)
+	if err != nil {
+		return 0
+	}
+	return h.Size()
+}
+
+// ChecksumFromURL extracts a digest given as a URL fragment such as "#sha256=<hex>"
+func ChecksumFromURL(rawURL string) (algorithm, digest string, ok bool) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Fragment == "" || !strings.Contains(parsed.Fragment, "=") {
+		return "", "", false
+	}
+	algorithm, digest, err = ParseChecksum(parsed.Fragment)
+	return algorithm, digest, err == nil
+}
+
+// SetChecksum sets the digest the completed file must match
+func (d *Download) SetChecksum(spec string) error {
+	algorithm, digest, err := ParseChecksum(spec)
+	if err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.ChecksumAlgorithm = algorithm
+	d.ExpectedChecksum = digest
+	d.ComputedChecksum = ""
+	return nil
+}
+
+// newStreamHasher returns a hash already fed with the first startByte bytes of the
+// partial file, or nil when no checksum is expected
+func (d *Download) newStreamHasher(startByte int64) (hash.Hash, error) {
+	d.mutex.Lock()
+	algorithm := d.ChecksumAlgorithm
+	d.mutex.Unlock()
+	if algorithm == "" {
+		return nil, nil
+	}
+
+	h, err := newHasher(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if startByte == 0 {
+		return h, nil
+	}
+
+	file, err := os.Open(d.PartPath())
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := io.CopyN(h, file, startByte); err != nil {
+		return nil, fmt.Errorf("failed to hash partial file: %w", err)
+	}
+	return h, nil
+}
+
+// finishPart verifies the completed .part file and moves it into place. A file that
+// fails verification never takes the target's name; it is kept as <target>.corrupt
+// so the user can inspect it, until the download is retried.
+func (d *Download) finishPart(h hash.Hash) error {
+	if err := d.verifyPart(h); err != nil {
+		if errors.Is(err, ErrVerifyFailed) {
+			d.keepCorrupt()
+		}
+		return err
+	}
+	return d.finalizePart()
+}
+
+// CorruptPath returns where a download that failed verification is kept
+func (d *Download) CorruptPath() string {
+	return d.TargetPath + ".corrupt"
+}
+
+// keepCorrupt moves a .part file that failed verification aside and drops its sidecar,
+// so a retry starts from scratch
+func (d *Download) keepCorrupt() {
+	if err := os.Rename(d.PartPath(), d.CorruptPath()); err != nil {
+		logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("failed to keep unverified file: %v", err))
+		return
+	}
+	if err := os.Remove(d.metaPath()); err != nil && !os.IsNotExist(err) {
+		logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("failed to remove metadata file: %v", err))
+	}
+	logger.LogDownloadPending(d.URL, d.Queue, fmt.Sprintf("Kept unverified file as %s", d.CorruptPath()))
+}
+
+// verifyPart compares the digest of the completed .part file with ExpectedChecksum.
+// h is the hash computed while streaming; if nil the file is read back from disk.
+func (d *Download) verifyPart(h hash.Hash) error {
+	d.mutex.Lock()
+	algorithm, expected := d.ChecksumAlgorithm, d.ExpectedChecksum
+	d.mutex.Unlock()
+	if algorithm == "" || expected == "" {
+		return nil
+	}
+
+	if h == nil {
+		var err error
+		if h, err = newHasher(algorithm); err != nil {
+			return err
+		}
+		file, err := os.Open(d.PartPath())
+		if err != nil {
+			return fmt.Errorf("failed to open file for verification: %w", err)
+		}
+		defer file.Close()
+		if _, err := io.Copy(h, file); err != nil {
+			return fmt.Errorf("failed to hash file: %w", err)
+		}
+	}
+
+	computed := hex.EncodeToString(h.Sum(nil))
+	d.mutex.Lock()
+	d.ComputedChecksum = computed
+	d.mutex.Unlock()
+
+	if computed != expected {
+		errorMsg := fmt.Sprintf("%s mismatch: expected %s, got %s", algorithm, expected, computed)
+		logger.LogDownloadError(d.URL, d.Queue, errorMsg)
+		return fmt.Errorf("%w: %s", ErrVerifyFailed, errorMsg)
+	}
+
+	logger.LogDownloadEvent("VERIFY", fmt.Sprintf("Checksum verified for %s (%s: %s)", d.URL, algorithm, computed))
+	return nil
+}
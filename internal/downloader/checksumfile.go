@@ -0,0 +1,153 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/mahdiXak47/Download-Manager/internal/logger"
+)
+
+// maxChecksumFileSize bounds how much of a published checksum file is read
+const maxChecksumFileSize = 1024 * 1024
+
+// checksumSidecars lists per-file checksum suffixes with their algorithm, strongest first
+var checksumSidecars = []struct {
+	suffix    string
+	algorithm string
+}{
+	{".sha512", "sha512"},
+	{".sha256", "sha256"},
+	{".sha1", "sha1"},
+	{".md5", "md5"},
+}
+
+// checksumLists lists directory-wide checksum files with their algorithm, strongest first
+var checksumLists = []struct {
+	name      string
+	algorithm string
+}{
+	{"SHA512SUMS", "sha512"},
+	{"SHA256SUMS", "sha256"},
+	{"SHA1SUMS", "sha1"},
+	{"MD5SUMS", "md5"},
+}
+
+// lookupChecksum looks for a checksum published next to the download, either as
+// <file>.sha256 style sidecars or in a SHA256SUMS style list, and sets it as expected
+func (d *Download) lookupChecksum(ctx context.Context) error {
+	parsed, err := url.Parse(d.URL)
+	if err != nil {
+		return err
+	}
+	parsed.Fragment = ""
+	parsed.RawQuery = ""
+	filename := path.Base(parsed.Path)
+	if filename == "/" || filename == "." {
+		return fmt.Errorf("no file name in URL to look up a checksum for")
+	}
+
+	for _, sidecar := range checksumSidecars {
+		candidate := *parsed
+		candidate.Path += sidecar.suffix
+		candidate.RawPath = ""
+		if digest, err := d.fetchChecksum(ctx, candidate.String(), filename, sidecar.algorithm, true); err == nil {
+			return d.useFetchedChecksum(sidecar.algorithm, digest, candidate.String())
+		}
+	}
+
+	for _, list := range checksumLists {
+		candidate := *parsed
+		candidate.Path = path.Join(path.Dir(parsed.Path), list.name)
+		candidate.RawPath = ""
+		if digest, err := d.fetchChecksum(ctx, candidate.String(), filename, list.algorithm, false); err == nil {
+			return d.useFetchedChecksum(list.algorithm, digest, candidate.String())
+		}
+	}
+
+	return fmt.Errorf("no published checksum found for %s", filename)
+}
+
+// useFetchedChecksum records a digest found in a published checksum file
+func (d *Download) useFetchedChecksum(algorithm, digest, source string) error {
+	if err := d.SetChecksum(algorithm + ":" + digest); err != nil {
+		return fmt.Errorf("invalid checksum in %s: %w", source, err)
+	}
+	logger.LogDownloadEvent("VERIFY", fmt.Sprintf("Using %s checksum from %s for %s", algorithm, source, d.URL))
+	return nil
+}
+
+// fetchChecksum downloads a checksum file and returns the digest listed for filename.
+// A sidecar may hold just the digest, without a file name.
+func (d *Download) fetchChecksum(ctx context.Context, checksumURL, filename, algorithm string, sidecar bool) (string, error) {
+	req, err := d.newRequest(ctx, "GET", checksumURL)
+	if err != nil {
+		return "", err
+	}
+	resp, err := d.doWatched(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("server responded with status: %s", resp.Status)
+	}
+
+	digest, err := parseChecksumList(io.LimitReader(resp.Body, maxChecksumFileSize), filename, algorithm, sidecar)
+	if err != nil {
+		return "", err
+	}
+	return digest, nil
+}
+
+// parseChecksumList finds the digest for filename in GNU ("<hex>  file", "<hex> *file")
+// or BSD ("SHA256 (file) = <hex>") formatted checksum output
+func parseChecksumList(r io.Reader, filename, algorithm string, allowBare bool) (string, error) {
+	digestLen := 2 * hasherSize(algorithm)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// BSD style
+		if open := strings.Index(line, " ("); open > 0 {
+			if closing := strings.LastIndex(line, ") = "); closing > open {
+				name := line[open+2 : closing]
+				digest := strings.ToLower(strings.TrimSpace(line[closing+4:]))
+				if normalizeAlgorithm(line[:open]) == algorithm && path.Base(name) == filename && len(digest) == digestLen {
+					return digest, nil
+				}
+				continue
+			}
+		}
+
+		// GNU style, or a bare digest in a sidecar
+		fields := strings.Fields(line)
+		digest := strings.ToLower(fields[0])
+		if len(digest) != digestLen {
+			continue
+		}
+		if len(fields) == 1 {
+			if allowBare {
+				return digest, nil
+			}
+			continue
+		}
+		name := strings.TrimPrefix(strings.Join(fields[1:], " "), "*")
+		if path.Base(name) == filename {
+			return digest, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no %s entry for %s", algorithm, filename)
+}
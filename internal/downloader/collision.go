@@ -0,0 +1,153 @@
+package downloader
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mahdiXak47/Download-Manager/internal/logger"
+)
+
+// Collision policies decide what happens when the target file already exists
+const (
+	CollisionOverwrite = "overwrite" // replace the existing file
+	CollisionRename    = "rename"    // save as "file (1).ext"
+	CollisionSkip      = "skip"      // keep the existing file if it is identical, otherwise rename
+	CollisionAsk       = "ask"       // stop and let the user decide
+)
+
+// ErrTargetExists is returned when the target file exists and the user has to decide what to do
+var ErrTargetExists = errors.New("target file already exists")
+
+// claimTarget applies the collision policy before a fresh download opens its file.
+// It returns true when an identical file is already in place and nothing needs downloading.
+func (d *Download) claimTarget(totalSize int64) (bool, error) {
+	// Another download writing to the same name must not share its .part file,
+	// whatever the policy
+	d.avoidOthersPart()
+
+	d.mutex.Lock()
+	policy, targetPath := d.CollisionPolicy, d.TargetPath
+	d.mutex.Unlock()
+
+	info, err := os.Stat(targetPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check target file: %w", err)
+	}
+
+	switch policy {
+	case CollisionOverwrite, "":
+		logger.LogDownloadPending(d.URL, d.Queue, fmt.Sprintf("Overwriting existing file %s", targetPath))
+		return false, nil
+
+	case CollisionAsk:
+		errorMsg := fmt.Sprintf("%s already exists, choose overwrite, rename or skip", targetPath)
+		logger.LogDownloadPending(d.URL, d.Queue, errorMsg)
+		return false, fmt.Errorf("%w: %s", ErrTargetExists, targetPath)
+
+	case CollisionSkip:
+		identical, err := d.isIdentical(targetPath, info, totalSize)
+		if err != nil {
+			return false, err
+		}
+		if identical {
+			d.mutex.Lock()
+			d.TotalSize = info.Size()
+			d.Downloaded = info.Size()
+			d.mutex.Unlock()
+			logger.LogDownloadPending(d.URL, d.Queue, fmt.Sprintf("Skipping, identical file already at %s", targetPath))
+			return true, nil
+		}
+	}
+
+	// Rename, and skip when the existing file differs
+	newPath := nextFreePath(targetPath)
+	d.mutex.Lock()
+	d.TargetPath = newPath
+	d.Filename = filepath.Base(newPath)
+	d.mutex.Unlock()
+	logger.LogDownloadPending(d.URL, d.Queue, fmt.Sprintf("%s already exists, saving as %s", targetPath, newPath))
+	return false, nil
+}
+
+// avoidOthersPart moves the download to a free name when the .part file at its
+// target belongs to another download, so the two never write to the same file
+func (d *Download) avoidOthersPart() {
+	if !d.partBelongsToOther() {
+		return
+	}
+	d.mutex.Lock()
+	targetPath := d.TargetPath
+	newPath := nextFreePath(targetPath)
+	d.TargetPath = newPath
+	d.Filename = filepath.Base(newPath)
+	d.mutex.Unlock()
+	logger.LogDownloadPending(d.URL, d.Queue, fmt.Sprintf("%s is being downloaded by another download, saving as %s", targetPath, newPath))
+}
+
+// partBelongsToOther reports whether a .part file at the target is someone else's.
+// Only a sidecar naming this download's URL makes it ours; one without a sidecar may
+// belong to a download that has only just opened it.
+func (d *Download) partBelongsToOther() bool {
+	if !exists(d.PartPath()) {
+		return false
+	}
+	meta, err := d.loadMetadata()
+	return err != nil || meta.URL != d.URL
+}
+
+// isIdentical compares an existing file with the download by size, and by checksum when one is expected
+func (d *Download) isIdentical(path string, info os.FileInfo, totalSize int64) (bool, error) {
+	if totalSize <= 0 || info.Size() != totalSize {
+		return false, nil
+	}
+
+	d.mutex.Lock()
+	algorithm, expected := d.ChecksumAlgorithm, d.ExpectedChecksum
+	d.mutex.Unlock()
+	if algorithm == "" || expected == "" {
+		return true, nil
+	}
+
+	h, err := newHasher(algorithm)
+	if err != nil {
+		return false, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open existing file: %w", err)
+	}
+	defer file.Close()
+	if _, err := io.Copy(h, file); err != nil {
+		return false, fmt.Errorf("failed to hash existing file: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)) == expected, nil
+}
+
+// nextFreePath returns "name (n).ext" for the lowest n not taken by a file or another download's .part file
+func nextFreePath(path string) string {
+	dir := filepath.Dir(path)
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(filepath.Base(path), ext)
+
+	for i := 1; ; i++ {
+		candidate := filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+		if exists(candidate) || exists(candidate+".part") {
+			continue
+		}
+		return candidate
+	}
+}
+
+// exists reports whether something is present at path
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
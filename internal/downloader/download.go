@@ -1,8 +1,11 @@
 package downloader
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -15,32 +18,66 @@ import (
 
 // Download represents a download task with its state and control channels
 type Download struct {
-	URL                string    `json:"url"`
-	TargetPath         string    `json:"target_path"`
-	Filename           string    `json:"filename"`
-	Queue              string    `json:"queue"`
-	Status             string    `json:"status"` // pending, downloading, paused, completed, error, cancelled
-	Progress           float64   `json:"progress"`
-	Speed              int64     `json:"speed"` // bytes per second
-	TotalSize          int64     `json:"total_size"`
-	Downloaded         int64     `json:"downloaded"`
-	Error              string    `json:"error,omitempty"`
-	MaxBandwidth       int64     `json:"max_bandwidth"` // in KB/s, 0 means unlimited
-	StartTime          time.Time `json:"start_time,omitempty"`
-	CompletionTime     time.Time `json:"completion_time,omitempty"`
-	ScheduledStartTime time.Time `json:"scheduled_start_time,omitempty"`
+	URL                string            `json:"url"`
+	TargetPath         string            `json:"target_path"`
+	Filename           string            `json:"filename"`
+	NameFromServer     bool              `json:"name_from_server,omitempty"` // Filename is provisional until the server suggests one
+	Queue              string            `json:"queue"`
+	Status             string            `json:"status"` // pending, downloading, paused, completed, error, cancelled, verify-failed, conflict, blocked
+	Progress           float64           `json:"progress"`
+	Speed              int64             `json:"speed"` // bytes per second
+	TotalSize          int64             `json:"total_size"`
+	Downloaded         int64             `json:"downloaded"`
+	Error              string            `json:"error,omitempty"`
+	MaxBandwidth       int64             `json:"max_bandwidth"` // in KB/s, 0 means unlimited
+	StartTime          time.Time         `json:"start_time,omitempty"`
+	CompletionTime     time.Time         `json:"completion_time,omitempty"`
+	ScheduledStartTime time.Time         `json:"scheduled_start_time,omitempty"`
+	SegmentCount       int               `json:"segment_count"`      // parallel connections, 0 or 1 for a single stream
+	Segments           []Segment         `json:"segments,omitempty"` // byte ranges of a multi-connection download
+	ETag               string            `json:"etag,omitempty"`
+	LastModified       string            `json:"last_modified,omitempty"`
+	ChecksumAlgorithm  string            `json:"checksum_algorithm,omitempty"` // md5, sha1, sha256 or sha512
+	ExpectedChecksum   string            `json:"expected_checksum,omitempty"`
+	ComputedChecksum   string            `json:"computed_checksum,omitempty"`
+	FetchChecksum      bool              `json:"fetch_checksum,omitempty"`   // look for SHA256SUMS or <file>.sha256 next to the URL
+	CollisionPolicy    string            `json:"collision_policy,omitempty"` // overwrite, rename, skip or ask when TargetPath exists
+	Headers            map[string]string `json:"headers,omitempty"`          // extra request headers, e.g. Authorization
+	CookieFile         string            `json:"cookie_file,omitempty"`      // Netscape/curl cookies.txt sent with requests
+	UserAgent          string            `json:"user_agent,omitempty"`       // empty for DefaultUserAgent
+	Priority           int               `json:"priority,omitempty"`         // higher starts first within the queue
+	Position           int               `json:"position,omitempty"`         // manual order within the queue among equal priorities
+	DependsOn          []string          `json:"depends_on,omitempty"`       // URLs that must complete before this download starts
+	RetryPolicy        RetryPolicy       `json:"retry_policy,omitempty"`     // when to try again after a failure
+	StallPolicy        StallPolicy       `json:"stall_policy,omitempty"`     // when to reconnect a connection that stopped delivering
+	Attempts           []Attempt         `json:"attempts,omitempty"`         // the most recent failed tries and their causes
 
 	// Control fields (not persisted to JSON)
-	pauseChan   chan struct{} `json:"-"`
-	resumeChan  chan struct{} `json:"-"`
-	cancelChan  chan struct{} `json:"-"`
-	isPaused    bool          `json:"-"`
-	isCancelled bool          `json:"-"`
-	mutex       sync.Mutex    `json:"-"`
-	retryCount  int           `json:"retry_count"`
-	maxRetries  int           `json:"max_retries"`
-	retryDelay  time.Duration `json:"-"`
-	client      *http.Client  `json:"-"`
+	cancel      context.CancelCauseFunc `json:"-"` // stops the running attempt, see Pause and Cancel
+	resumed     chan struct{}           `json:"-"` // closed when a paused download may go on
+	isPaused    bool                    `json:"-"`
+	isCancelled bool                    `json:"-"`
+	mutex       sync.Mutex              `json:"-"`
+	retryCount  int                     `json:"retry_count"`
+	client      *http.Client            `json:"-"`
+	limiter     *RateLimiter            `json:"-"` // own bandwidth limit, see SetBandwidth
+	proxy       string                  `json:"-"` // from the queue's settings when started, see SetProxy
+	noProxy     []string                `json:"-"`
+}
+
+// errPaused and errCancelled are the causes Pause and Cancel stop a running download with
+var (
+	errPaused    = errors.New("download paused")
+	errCancelled = errors.New("download cancelled")
+)
+
+// StartsBefore reports whether a should start before b when both are waiting in the
+// same queue: higher priority first, then lower position
+func StartsBefore(a, b *Download) bool {
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	return a.Position < b.Position
 }
 
 // DownloadResult represents the outcome of a download attempt
@@ -52,35 +89,24 @@ type DownloadResult struct {
 	ShouldRetry bool
 }
 
-// Initialize sets up control channels for a download
+// Initialize sets up the HTTP client and default fields for a download
 func (d *Download) Initialize() {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
-	if d.pauseChan == nil {
-		d.pauseChan = make(chan struct{}, 1)
-	}
-	if d.resumeChan == nil {
-		d.resumeChan = make(chan struct{}, 1)
-	}
-	if d.cancelChan == nil {
-		d.cancelChan = make(chan struct{}, 1)
-	}
 	if d.Status == "" {
 		d.Status = "pending"
 		logger.LogDownloadPending(d.URL, d.Queue, "Initialized download")
 	}
-	if d.maxRetries == 0 {
-		d.maxRetries = 3
-	}
-	if d.retryDelay == 0 {
-		d.retryDelay = 5 * time.Second
-	}
 	if d.client == nil {
-		// Configure HTTP client with more lenient timeouts
+		// Configure HTTP client with more lenient timeouts. There is no overall timeout,
+		// which would cut off any download that takes longer: connecting, the TLS
+		// handshake and the response headers each have their own limit, and a body
+		// that stops arriving is caught by the StallPolicy.
 		d.client = &http.Client{
-			Timeout: 60 * time.Second,
 			Transport: &http.Transport{
+				Proxy:                 d.proxyFor,
+				DialContext:           (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
 				TLSHandshakeTimeout:   30 * time.Second,
 				ResponseHeaderTimeout: 30 * time.Second,
 				ExpectContinueTimeout: 5 * time.Second,
@@ -90,15 +116,24 @@ func (d *Download) Initialize() {
 			},
 		}
 	}
+	if d.CookieFile != "" && d.client.Jar == nil {
+		jar, err := loadCookieJar(d.CookieFile)
+		if err != nil {
+			logger.LogDownloadError(d.URL, d.Queue, err.Error())
+		} else {
+			d.client.Jar = jar
+		}
+	}
 	if d.Filename == "" && d.URL != "" {
-		d.Filename = filepath.Base(d.URL)
+		d.Filename = FilenameFromURL(d.URL)
 	}
 	if d.TargetPath == "" && d.Filename != "" {
 		d.TargetPath = d.Filename
 	}
 }
 
-// Pause signals the download to pause
+// Pause stops the download's connection straight away, even mid-read; Start then
+// waits for Resume and carries on from the bytes on disk
 func (d *Download) Pause() {
 	d.mutex.Lock()
 	oldStatus := d.Status
@@ -109,15 +144,16 @@ func (d *Download) Pause() {
 		d.isPaused = true
 		// Log status change to paused
 		logger.LogDownloadStatus(d.URL, oldStatus, d.Status, d.Downloaded, d.TotalSize)
-		select {
-		case d.pauseChan <- struct{}{}:
-		default:
+		d.resumed = make(chan struct{})
+		if d.cancel != nil {
+			d.cancel(errPaused)
 		}
 	}
 }
 
-// Resume signals the download to resume
-func (d *Download) Resume() {
+// Resume lets a paused download carry on. It reports whether a Start was waiting to
+// do so; a download paused before the app last exited has none and must be started again.
+func (d *Download) Resume() bool {
 	d.mutex.Lock()
 	oldStatus := d.Status
 	defer d.mutex.Unlock()
@@ -127,14 +163,22 @@ func (d *Download) Resume() {
 		d.isPaused = false
 		// Log status change to downloading (resumed)
 		logger.LogDownloadStatus(d.URL, oldStatus, d.Status, d.Downloaded, d.TotalSize)
-		select {
-		case d.resumeChan <- struct{}{}:
-		default:
-		}
+		d.signalResume()
+		return true
 	}
+	return false
 }
 
-// Cancel stops the download and removes temporary files
+// signalResume wakes a Start waiting for the download to be resumed. Callers hold the mutex.
+func (d *Download) signalResume() {
+	if d.resumed != nil {
+		close(d.resumed)
+		d.resumed = nil
+	}
+}
+
+// Cancel stops the download straight away, even mid-read or while it waits, and
+// removes temporary files
 func (d *Download) Cancel() error {
 	d.mutex.Lock()
 	oldStatus := d.Status
@@ -145,14 +189,14 @@ func (d *Download) Cancel() error {
 		d.isCancelled = true
 		// Log status change to cancelled
 		logger.LogDownloadStatus(d.URL, oldStatus, d.Status, d.Downloaded, d.TotalSize)
-		select {
-		case d.cancelChan <- struct{}{}:
-		default:
+		if d.cancel != nil {
+			d.cancel(errCancelled)
 		}
+		d.signalResume()
 
-		// Only attempt to remove the file if it was created
-		if d.TargetPath != "" && d.Progress > 0 {
-			if err := os.Remove(d.TargetPath); err != nil && !os.IsNotExist(err) {
+		// Remove any partial data left on disk
+		if d.TargetPath != "" {
+			if err := d.removePartFiles(); err != nil {
 				errorMsg := fmt.Sprintf("failed to remove file: %v", err)
 				logger.LogDownloadError(d.URL, d.Queue, errorMsg)
 				return fmt.Errorf("failed to remove file: %v", err)
@@ -167,16 +211,21 @@ func (d *Download) Retry() error {
 	d.mutex.Lock()
 	defer d.mutex.Unlock()
 
-	if d.Status == "error" {
+	if d.Status == "error" || d.Status == "verify-failed" {
 		oldStatus := d.Status // Save the old status for logging
 		d.Status = "pending"
 		d.Error = ""
 		d.Progress = 0
 		d.Speed = 0
 		d.Downloaded = 0
-		d.retryCount++
+		d.Segments = nil
+		d.ComputedChecksum = ""
+		// The file that failed verification is of no use once a new copy is fetched
+		if err := os.Remove(d.CorruptPath()); err != nil && !os.IsNotExist(err) {
+			logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("failed to remove unverified file: %v", err))
+		}
 		// Log status change to pending (retry)
-		logger.LogDownloadPending(d.URL, d.Queue, fmt.Sprintf("Retry attempt %d of %d", d.retryCount, d.maxRetries))
+		logger.LogDownloadPending(d.URL, d.Queue, "Retrying on request")
 		// Log the status change
 		logger.LogDownloadStatus(d.URL, oldStatus, d.Status, 0, d.TotalSize)
 		return nil
@@ -198,6 +247,13 @@ func (d *Download) GetProgress() float64 {
 	return d.Progress
 }
 
+// GetDownloaded returns the number of bytes downloaded so far
+func (d *Download) GetDownloaded() int64 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.Downloaded
+}
+
 // GetSpeed returns the current download speed in bytes per second
 func (d *Download) GetSpeed() int64 {
 	d.mutex.Lock()
@@ -212,14 +268,18 @@ func (d *Download) GetRetryCount() int {
 	return d.retryCount
 }
 
-// Start begins downloading a file with optional progress callback
-func (d *Download) Start() error {
-	// Initialize control channels and fields
+// Start downloads the file, returning once it has completed, failed or been cancelled.
+// Pausing the download keeps Start waiting for Resume. Ending ctx stops the download
+// the way Cancel does, but leaves the partial data for a later run.
+func (d *Download) Start(ctx context.Context) error {
+	// Initialize the client and fields
 	d.Initialize()
 	d.mutex.Lock()
 	oldStatus := d.Status
 	d.Status = "downloading"
 	d.StartTime = time.Now()
+	d.isPaused = false
+	d.retryCount = 0
 	d.mutex.Unlock()
 
 	// Log download start
@@ -227,15 +287,106 @@ func (d *Download) Start() error {
 	// Log status change
 	logger.LogDownloadStatus(d.URL, oldStatus, "downloading", 0, d.TotalSize)
 
+	for {
+		runCtx, cancel := d.newRun(ctx)
+		err := d.run(runCtx)
+		cancel(nil)
+
+		if errors.Is(err, errCancelled) {
+			// Cancel removed the partial files, but the attempt may have written more since
+			if err := d.removePartFiles(); err != nil {
+				logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("failed to remove file: %v", err))
+			}
+			return err
+		}
+		if !errors.Is(err, errPaused) {
+			return err
+		}
+
+		// Carry on from the bytes on disk once resumed
+		logger.LogDownloadPending(d.URL, d.Queue, "Paused, waiting to be resumed")
+		if err := d.waitForResume(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// newRun derives the context of one run of the download, which Pause and Cancel end.
+// A pause or cancel that came before the run began ends it straight away.
+func (d *Download) newRun(ctx context.Context) (context.Context, context.CancelCauseFunc) {
+	runCtx, cancel := context.WithCancelCause(ctx)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.cancel = cancel
+	if d.isCancelled {
+		cancel(errCancelled)
+	} else if d.isPaused {
+		cancel(errPaused)
+	}
+	return runCtx, cancel
+}
+
+// waitForResume blocks while the download is paused. It returns errCancelled if the
+// download is cancelled instead, and the cause of ctx if that ends first.
+func (d *Download) waitForResume(ctx context.Context) error {
+	d.mutex.Lock()
+	resumed := d.resumed
+	d.mutex.Unlock()
+
+	if resumed != nil {
+		select {
+		case <-resumed:
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.isCancelled {
+		return errCancelled
+	}
+	return nil
+}
+
+// sleep waits for duration, returning early with the cause if ctx ends first
+func sleep(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// run waits for the scheduled start time and tries the download as the retry policy
+// allows. It returns errPaused or errCancelled as soon as ctx is ended by Pause or Cancel.
+func (d *Download) run(ctx context.Context) error {
 	if !d.ScheduledStartTime.IsZero() && time.Now().Before(d.ScheduledStartTime) {
-		waitDuration := d.ScheduledStartTime.Sub(time.Now())
 		logger.LogDownloadPending(d.URL, d.Queue, fmt.Sprintf("Waiting for scheduled start time: %v", d.ScheduledStartTime))
-		time.Sleep(waitDuration)
+		if err := sleep(ctx, time.Until(d.ScheduledStartTime)); err != nil {
+			return err
+		}
+	}
+
+	// Look up a published checksum when none was given
+	if d.FetchChecksum && d.ExpectedChecksum == "" {
+		if err := d.lookupChecksum(ctx); err != nil {
+			if cause := context.Cause(ctx); cause != nil {
+				return cause
+			}
+			logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("Checksum lookup failed, download will not be verified: %v", err))
+		}
 	}
 
-	// Main download loop with retry logic
-	for d.retryCount <= d.maxRetries {
-		err := d.performDownload()
+	// Main download loop, trying again as the retry policy allows. Retries made
+	// before a pause still count.
+	policy := d.RetryPolicy.withDefaults()
+	for attempt := d.GetRetryCount() + 1; ; attempt++ {
+		err := d.performDownload(ctx)
 		if err == nil {
 			// Download completed successfully
 			d.mutex.Lock()
@@ -254,49 +405,78 @@ func (d *Download) Start() error {
 			return nil
 		}
 
-		// Check if download was cancelled
+		// A pause or cancel ends the request, which is not a failure of the download
+		if cause := context.Cause(ctx); cause != nil {
+			return cause
+		}
 		d.mutex.Lock()
-		if d.isCancelled {
+
+		// An existing target file needs a decision from the user first
+		if errors.Is(err, ErrTargetExists) {
+			oldStatus := d.Status
+			d.Status = "conflict"
+			d.Error = err.Error()
+			d.mutex.Unlock()
+			logger.LogDownloadStatus(d.URL, oldStatus, "conflict", d.Downloaded, d.TotalSize)
+			return err
+		}
+
+		// A file that downloaded fine but fails verification won't improve by retrying
+		if errors.Is(err, ErrVerifyFailed) {
+			oldStatus := d.Status
+			d.Status = "verify-failed"
+			d.Error = err.Error()
+			d.CompletionTime = time.Now()
 			d.mutex.Unlock()
-			logger.LogDownloadStatus(d.URL, "downloading", "cancelled", d.Downloaded, d.TotalSize)
-			return fmt.Errorf("download cancelled")
+			logger.LogDownloadStatus(d.URL, oldStatus, "verify-failed", d.Downloaded, d.TotalSize)
+			return err
 		}
 
 		// Handle error and retry if possible
+		cause, retryable, retryAfter := ClassifyError(err)
+		d.recordAttempt(err, cause, retryable)
 		oldStatus := d.Status
 		d.Status = "error"
 		d.Error = err.Error()
 
 		// Log error status
-		logger.LogDownloadError(d.URL, d.Queue, err.Error())
+		logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("Attempt %d of %d failed (%s): %v", attempt, policy.MaxAttempts, cause, err))
 		logger.LogDownloadStatus(d.URL, oldStatus, "error", d.Downloaded, d.TotalSize)
 
-		// Check if we should retry
-		if d.retryCount < d.maxRetries {
+		// Permanent failures such as a 404 won't improve by trying again
+		if !retryable {
+			d.mutex.Unlock()
+			finalError := fmt.Errorf("download failed (%s, not retried): %w", cause, err)
+			logger.LogDownloadError(d.URL, d.Queue, finalError.Error())
+			return finalError
+		}
+
+		if attempt < policy.MaxAttempts {
+			// Still downloading while it waits: Start keeps the .part file, so the
+			// manager must not start the download again, and Pause must still stop it
 			d.retryCount++
-			d.Status = "pending"
-			retryMsg := fmt.Sprintf("Retry attempt %d of %d after error: %s",
-				d.retryCount, d.maxRetries, err.Error())
+			d.Status = "downloading"
+			delay := policy.Delay(attempt, retryAfter)
+			retryMsg := fmt.Sprintf("Retry attempt %d of %d in %s after %s error: %s",
+				d.retryCount, policy.MaxAttempts-1, delay.Round(time.Second), cause, err.Error())
 			logger.LogDownloadPending(d.URL, d.Queue, retryMsg)
-			logger.LogDownloadStatus(d.URL, "error", "pending", d.Downloaded, d.TotalSize)
+			logger.LogDownloadStatus(d.URL, "error", "downloading", d.Downloaded, d.TotalSize)
 			d.mutex.Unlock()
-			time.Sleep(d.retryDelay)
+			if err := sleep(ctx, delay); err != nil {
+				return err
+			}
 			continue
 		}
 
 		d.mutex.Unlock()
-		finalError := fmt.Errorf("download failed after %d retries: %v", d.maxRetries, err)
+		finalError := fmt.Errorf("download failed after %d attempts: %w", policy.MaxAttempts, err)
 		logger.LogDownloadError(d.URL, d.Queue, finalError.Error())
 		return finalError
 	}
-
-	finalError := fmt.Errorf("download failed after %d retries", d.maxRetries)
-	logger.LogDownloadError(d.URL, d.Queue, finalError.Error())
-	return finalError
 }
 
 // performDownload handles the actual file download process
-func (d *Download) performDownload() error {
+func (d *Download) performDownload(ctx context.Context) error {
 	// Ensure queue name is valid
 	if d.Queue == "" {
 		d.Queue = "default"
@@ -317,81 +497,141 @@ func (d *Download) performDownload() error {
 	var totalSize int64
 	var supportsRanges bool
 
-	resp, err := d.client.Head(d.URL)
+	// Only a successful HEAD describes the file; the headers of an error such as a 405
+	// would clear the validators a resume relies on
+	resp, err := d.head(ctx)
 	if err == nil {
 		defer resp.Body.Close()
+	}
+	headOK := err == nil && resp.StatusCode == http.StatusOK
+	if headOK {
 		totalSize, _ = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
 		supportsRanges = resp.Header.Get("Accept-Ranges") == "bytes"
+		d.resolveFilename(resp)
+	}
+
+	// A .part file left at the target by another download is not ours to resume
+	d.avoidOthersPart()
+
+	if headOK {
+		// Partial data from a different version of the file is useless
+		if _, err := os.Stat(d.PartPath()); err == nil {
+			if reason := d.remoteChangeReason(resp); reason != "" {
+				d.restartFromZero(reason)
+			}
+		}
+		d.recordValidators(resp)
+	}
+
+	if d.useSegments(totalSize, supportsRanges) {
+		err := d.downloadSegmented(ctx, totalSize)
+		if errors.Is(err, errRemoteChanged) {
+			// The segments were reset, fetch the new version from scratch
+			err = d.downloadSegmented(ctx, totalSize)
+		}
+		return err
 	}
 
 	// Create the GET request
-	req, err := http.NewRequest("GET", d.URL, nil)
+	req, err := d.newRequest(ctx, "GET", d.URL)
 	if err != nil {
 		errorMsg := fmt.Sprintf("failed to create request: %v", err)
 		logger.LogDownloadError(d.URL, d.Queue, errorMsg)
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 
-	// If we're resuming and we know the server supports ranges, set the range header
+	// Resume from wherever the bytes on disk end
+	var startByte int64
 	d.mutex.Lock()
-	startByte := d.Downloaded
+	segmented := len(d.Segments) > 0
+	d.Segments = nil
 	d.mutex.Unlock()
 
-	if startByte > 0 && supportsRanges {
+	if segmented {
+		// Bytes from a segmented attempt are not contiguous, so start over
+		d.resetProgress()
+	} else if info, err := os.Stat(d.PartPath()); err == nil {
+		startByte = info.Size()
+	}
+
+	// A server that won't answer HEAD may still honour a range; if it sends the whole
+	// file instead, the download starts over below
+	validator := d.ifRangeValidator()
+	if startByte > 0 && (supportsRanges || !headOK) {
 		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", startByte))
+		// Only honour the range if the file is still the one we started on
+		if validator != "" {
+			req.Header.Set("If-Range", validator)
+		}
 	}
 
 	// Send the request
-	resp, err = d.client.Do(req)
+	resp, err = d.doWatched(req)
 	if err != nil {
-		// Check for network-related errors
-		if os.IsTimeout(err) || err == io.ErrUnexpectedEOF || err == io.EOF {
-			d.mutex.Lock()
-			d.Status = "paused"
-			d.isPaused = true
-			d.mutex.Unlock()
-			logger.LogDownloadStatus(d.URL, "downloading", "paused", d.Downloaded, d.TotalSize)
-			return fmt.Errorf("download paused due to network error: %w", err)
-		}
-
 		errorMsg := fmt.Sprintf("failed to send GET request: %v", err)
 		logger.LogDownloadError(d.URL, d.Queue, errorMsg)
 		return fmt.Errorf("failed to send GET request: %w", err)
 	}
 	defer resp.Body.Close()
 
+	// The partial file may already hold every byte if we died before renaming it, but
+	// it is verified like any other before it takes the target's name. Without a HEAD
+	// the size recorded when the download started stands in.
+	knownSize := totalSize
+	if !headOK {
+		d.mutex.Lock()
+		knownSize = d.TotalSize
+		d.mutex.Unlock()
+	}
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && startByte > 0 && startByte == knownSize {
+		return d.finishPart(nil)
+	}
+
 	// Check if the request was successful
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		errorMsg := fmt.Sprintf("server responded with status: %s", resp.Status)
-		logger.LogDownloadError(d.URL, d.Queue, errorMsg)
-		return fmt.Errorf("server responded with status: %s", resp.Status)
+		statusErr := newStatusError(resp)
+		logger.LogDownloadError(d.URL, d.Queue, statusErr.Error())
+		return statusErr
 	}
 
+	// Name the file now if the HEAD request couldn't
+	d.resolveFilename(resp)
+
 	// Update total size from GET response if we didn't get it from HEAD
 	if totalSize == 0 {
 		totalSize, _ = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
-		d.mutex.Lock()
-		d.TotalSize = totalSize
-		d.mutex.Unlock()
+		if resp.StatusCode == http.StatusPartialContent && totalSize > 0 {
+			totalSize += startByte
+		}
 	}
+	d.mutex.Lock()
+	d.TotalSize = totalSize
+	d.mutex.Unlock()
 
-	// If we got a 206 response, the server supports ranges
-	if resp.StatusCode == 206 {
-		supportsRanges = true
+	// A full response means the server ignored the range, so rewrite from the start
+	if resp.StatusCode != http.StatusPartialContent && startByte > 0 {
+		reason := "server does not support resuming"
+		if req.Header.Get("If-Range") != "" {
+			reason = "server reported the file changed (If-Range)"
+		}
+		d.restartFromZero(reason)
+		startByte = 0
 	}
+	d.recordValidators(resp)
 
-	// Prepare file for writing
-	var file *os.File
-	var openMode int
-
-	if startByte > 0 && supportsRanges {
-		openMode = os.O_WRONLY | os.O_APPEND
-	} else {
-		openMode = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
-		startByte = 0
+	// A fresh download must not clobber an existing file unless the policy says so
+	if startByte == 0 {
+		skip, err := d.claimTarget(totalSize)
+		if err != nil {
+			return err
+		}
+		if skip {
+			return nil
+		}
 	}
 
-	file, err = os.OpenFile(d.TargetPath, openMode, 0644)
+	// Prepare file for writing
+	file, err := os.OpenFile(d.PartPath(), os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		errorMsg := fmt.Sprintf("failed to open file: %v", err)
 		logger.LogDownloadError(d.URL, d.Queue, errorMsg)
@@ -399,39 +639,69 @@ func (d *Download) performDownload() error {
 	}
 	defer file.Close()
 
-	result := d.downloadChunks(resp.Body, file, startByte, totalSize)
+	if err := file.Truncate(startByte); err != nil {
+		return fmt.Errorf("failed to truncate partial file: %w", err)
+	}
+	if _, err := file.Seek(startByte, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek partial file: %w", err)
+	}
+	d.mutex.Lock()
+	d.Downloaded = startByte
+	d.mutex.Unlock()
+
+	if err := d.saveMetadata(); err != nil {
+		logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("failed to save metadata: %v", err))
+	}
 
-	if result.Error != nil && !result.ShouldRetry {
+	// Hash the data as it is written when a checksum is expected
+	var out io.Writer = file
+	hasher, err := d.newStreamHasher(startByte)
+	if err != nil {
+		logger.LogDownloadError(d.URL, d.Queue, err.Error())
+		return err
+	}
+	if hasher != nil {
+		out = io.MultiWriter(file, hasher)
+	}
+
+	result := d.downloadChunks(ctx, resp.Body, out, startByte, totalSize)
+
+	if err := d.saveMetadata(); err != nil {
+		logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("failed to save metadata: %v", err))
+	}
+
+	if result.Error != nil {
 		return result.Error
 	}
 
-	if result.Completed {
-		if totalSize <= 0 {
-			d.mutex.Lock()
-			d.TotalSize = result.Downloaded
-			d.Progress = 100.0
-			d.mutex.Unlock()
-		}
-		logger.LogDownloadStatus(d.URL, "downloading", "completed", result.Downloaded, result.Downloaded)
-		return nil
+	if !result.Completed {
+		return fmt.Errorf("download incomplete: got %d of %d bytes", result.Downloaded, result.TotalSize)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+	if err := d.finishPart(hasher); err != nil {
+		return err
 	}
 
-	if result.Downloaded > (result.TotalSize*95/100) && supportsRanges {
+	if totalSize <= 0 {
 		d.mutex.Lock()
-		d.Downloaded = result.Downloaded
+		d.TotalSize = result.Downloaded
+		d.Progress = 100.0
 		d.mutex.Unlock()
-		return nil
 	}
-
-	return fmt.Errorf("download incomplete: got %d of %d bytes", result.Downloaded, result.TotalSize)
+	logger.LogDownloadStatus(d.URL, "downloading", "completed", result.Downloaded, result.Downloaded)
+	return nil
 }
 
-// downloadChunks handles the actual data transfer
-func (d *Download) downloadChunks(body io.Reader, file *os.File, startByte, totalSize int64) DownloadResult {
-	// Setup rate limiting if needed
-	var limiter *RateLimiter
+// downloadChunks handles the actual data transfer. The body's request carries ctx,
+// so a pause or cancel ends a read in progress. A connection that stalls after
+// delivering data is reopened from the current offset without failing the attempt.
+func (d *Download) downloadChunks(ctx context.Context, body io.ReadCloser, out io.Writer, startByte, totalSize int64) DownloadResult {
+	// Setup rate limiting: the download's own limit plus the shared queue and global limits
+	limiter := d.newThrottle(ctx)
 	if d.MaxBandwidth > 0 {
-		limiter = NewRateLimiter(d.MaxBandwidth * 1024) // Convert KB/s to bytes/s
 		logger.LogDownloadPending(d.URL, d.Queue, fmt.Sprintf("Applying bandwidth limit of %d KB/s", d.MaxBandwidth))
 	}
 
@@ -441,41 +711,34 @@ func (d *Download) downloadChunks(body io.Reader, file *os.File, startByte, tota
 	startTime := time.Now()
 	lastUpdateTime := startTime
 	lastBytes := downloaded
+	connectedAt := downloaded // offset the current connection started from
 
-	// Start the download loop
-	for {
-		// Check if we should pause
-		select {
-		case <-d.pauseChan:
-			logger.LogDownloadStatus(d.URL, "downloading", "paused", downloaded, totalSize)
-			<-d.resumeChan
-			startTime = time.Now()
-			lastUpdateTime = startTime
-			lastBytes = downloaded
-			logger.LogDownloadStatus(d.URL, "paused", "downloading", downloaded, totalSize)
-			continue
-
-		case <-d.cancelChan:
-			logger.LogDownloadStatus(d.URL, "downloading", "cancelled", downloaded, totalSize)
-			return DownloadResult{
-				Completed:   false,
-				Downloaded:  downloaded,
-				TotalSize:   totalSize,
-				Error:       fmt.Errorf("download cancelled"),
-				ShouldRetry: false,
-			}
-
-		default:
-			// Proceed with download
+	// Bodies opened here on reconnecting are closed here; the first is the caller's
+	defer func() {
+		if connectedAt > startByte {
+			body.Close()
 		}
+	}()
 
+	// Start the download loop
+	for {
 		// Read chunk
 		var n int
 		var err error
-		if limiter != nil {
-			n, err = limiter.Read(body, buffer)
-		} else {
-			n, err = body.Read(buffer)
+		n, err = limiter.Read(body, buffer)
+
+		// A connection that stalls before delivering anything fails the attempt instead,
+		// so a server that never sends is left to the retry policy
+		if errors.Is(err, errStalled) && downloaded > connectedAt {
+			fresh, reconnectErr := d.reconnect(ctx, downloaded, err)
+			if reconnectErr == nil {
+				if connectedAt > startByte {
+					body.Close()
+				}
+				body, connectedAt = fresh, downloaded
+				continue
+			}
+			err = fmt.Errorf("%w, and reconnecting failed: %w", err, reconnectErr)
 		}
 
 		if err != nil && err != io.EOF {
@@ -493,7 +756,7 @@ func (d *Download) downloadChunks(body io.Reader, file *os.File, startByte, tota
 		}
 
 		// Write chunk
-		if _, err := file.Write(buffer[:n]); err != nil {
+		if _, err := out.Write(buffer[:n]); err != nil {
 			return DownloadResult{
 				Completed:   false,
 				Downloaded:  downloaded,
@@ -505,13 +768,13 @@ func (d *Download) downloadChunks(body io.Reader, file *os.File, startByte, tota
 
 		downloaded += int64(n)
 
-		// Update progress
+		// Update progress, counting bytes even when the size is unknown
+		d.mutex.Lock()
 		if totalSize > 0 {
-			d.mutex.Lock()
 			d.Progress = float64(downloaded) / float64(totalSize) * 100
-			d.Downloaded = downloaded
-			d.mutex.Unlock()
 		}
+		d.Downloaded = downloaded
+		d.mutex.Unlock()
 
 		// Calculate speed and log progress
 		now := time.Now()
@@ -551,10 +814,12 @@ func New(url, targetPath, queue string, maxBandwidth int64, scheduledStartTime t
 		Queue:              queue,
 		Status:             "pending",
 		MaxBandwidth:       maxBandwidth,
-		maxRetries:         3,
-		retryDelay:         5 * time.Second,
 		ScheduledStartTime: scheduledStartTime,
 	}
+	if algorithm, digest, ok := ChecksumFromURL(url); ok {
+		download.ChecksumAlgorithm = algorithm
+		download.ExpectedChecksum = digest
+	}
 	download.Initialize()
 	return download
 }
@@ -562,6 +827,6 @@ func New(url, targetPath, queue string, maxBandwidth int64, scheduledStartTime t
 // StartDownload is a convenience function to create and start a download
 func StartDownload(url, targetPath, queue string, maxBandwidth int64, scheduledStartTime time.Time) (*Download, error) {
 	download := New(url, targetPath, queue, maxBandwidth, scheduledStartTime)
-	go download.Start()
+	go download.Start(context.Background())
 	return download, nil
 }
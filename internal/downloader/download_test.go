@@ -0,0 +1,137 @@
+package downloader
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mahdiXak47/Download-Manager/internal/logger"
+)
+
+func TestMain(m *testing.M) {
+	// Keep the download log out of the source tree
+	dir, err := os.MkdirTemp("", "downloader-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := logger.Initialize(filepath.Join(dir, "download-logs.log")); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	logger.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newPartialDownload returns a download of url into a temporary directory whose .part
+// file and sidecar already hold part
+func newPartialDownload(t *testing.T, url string, part []byte, etag string) *Download {
+	t.Helper()
+	d := New(url, filepath.Join(t.TempDir(), "file.bin"), "default", 0, time.Time{})
+	d.ETag = etag
+	d.TotalSize = int64(len(part))
+	if err := os.WriteFile(d.PartPath(), part, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.saveMetadata(); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestResumeCompletePartIsVerified(t *testing.T) {
+	content := []byte("every byte already on disk")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Header().Set("Accept-Ranges", "bytes")
+			return
+		}
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+	}))
+	defer server.Close()
+
+	sum := sha256.Sum256(content)
+	good := hex.EncodeToString(sum[:])
+	bad := hex.EncodeToString(make([]byte, sha256.Size))
+
+	t.Run("mismatch", func(t *testing.T) {
+		d := newPartialDownload(t, server.URL+"/file.bin", content, "")
+		d.SetChecksum("sha256:" + bad)
+
+		if err := d.Start(context.Background()); !errors.Is(err, ErrVerifyFailed) {
+			t.Fatalf("Start returned %v, want %v", err, ErrVerifyFailed)
+		}
+		if status := d.GetStatus(); status != "verify-failed" {
+			t.Errorf("status = %q, want verify-failed", status)
+		}
+		if _, err := os.Stat(d.TargetPath); !os.IsNotExist(err) {
+			t.Errorf("unverified part was moved to the target")
+		}
+		if _, err := os.Stat(d.CorruptPath()); err != nil {
+			t.Errorf("unverified part not kept as %s: %v", d.CorruptPath(), err)
+		}
+	})
+
+	t.Run("match", func(t *testing.T) {
+		d := newPartialDownload(t, server.URL+"/file.bin", content, "")
+		d.SetChecksum("sha256:" + good)
+
+		if err := d.Start(context.Background()); err != nil {
+			t.Fatalf("Start returned %v", err)
+		}
+		if status := d.GetStatus(); status != "completed" {
+			t.Errorf("status = %q, want completed", status)
+		}
+		if data, err := os.ReadFile(d.TargetPath); err != nil || string(data) != string(content) {
+			t.Errorf("target holds %q (%v), want the part's bytes", data, err)
+		}
+	})
+}
+
+func TestResumeWhenHeadNotAllowed(t *testing.T) {
+	const etag = `"v1"`
+	content := []byte("the first half|and the second half")
+	half := len(content) / 2
+
+	var ifRange, rangeHeader string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			// An error response, whose headers say nothing about the file
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		ifRange, rangeHeader = r.Header.Get("If-Range"), r.Header.Get("Range")
+		w.Header().Set("ETag", etag)
+		if rangeHeader != "bytes="+strconv.Itoa(half)+"-" || ifRange != etag {
+			w.Write(content)
+			return
+		}
+		w.Header().Set("Content-Range", "bytes "+strconv.Itoa(half)+"-"+strconv.Itoa(len(content)-1)+"/"+strconv.Itoa(len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(content[half:])
+	}))
+	defer server.Close()
+
+	d := newPartialDownload(t, server.URL+"/file.bin", content[:half], etag)
+	d.TotalSize = int64(len(content))
+
+	if err := d.Start(context.Background()); err != nil {
+		t.Fatalf("Start returned %v", err)
+	}
+	if rangeHeader == "" || ifRange != etag {
+		t.Errorf("resume sent Range %q and If-Range %q, want the saved ETag %s", rangeHeader, ifRange, etag)
+	}
+	if data, err := os.ReadFile(d.TargetPath); err != nil || string(data) != string(content) {
+		t.Errorf("target holds %q (%v), want %q", data, err, content)
+	}
+}
@@ -0,0 +1,124 @@
+package downloader
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// defaultFilename is used when neither the server nor the URL suggest a name
+const defaultFilename = "download"
+
+// maxFilenameLength is the longest name, in bytes, most filesystems accept
+const maxFilenameLength = 255
+
+// reservedNames are device names Windows refuses as file names, with or without extension
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// FilenameFromURL returns the percent-decoded last path element of a URL, without query or fragment
+func FilenameFromURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return SanitizeFilename(path.Base(rawURL))
+	}
+	return filenameFromPath(parsed)
+}
+
+// filenameFromPath returns the sanitised last path element of a parsed URL
+func filenameFromPath(u *url.URL) string {
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		name = ""
+	}
+	return SanitizeFilename(name)
+}
+
+// FilenameFromResponse picks a file name for a response: the Content-Disposition
+// header first, then the path of the final URL after redirects
+func FilenameFromResponse(resp *http.Response) string {
+	if disposition := resp.Header.Get("Content-Disposition"); disposition != "" {
+		if _, params, err := mime.ParseMediaType(disposition); err == nil {
+			if name := SanitizeFilename(params["filename"]); name != defaultFilename {
+				return name
+			}
+		}
+	}
+	if resp.Request != nil && resp.Request.URL != nil {
+		return filenameFromPath(resp.Request.URL)
+	}
+	return defaultFilename
+}
+
+// SanitizeFilename turns an untrusted name into one that is safe to create in the
+// download directory: no directories, control or reserved characters, or device names
+func SanitizeFilename(name string) string {
+	// Keep only the last element of either kind of path separator
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`<>:"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, " .")
+
+	if name == "" {
+		return defaultFilename
+	}
+
+	ext := filepath.Ext(name)
+	if reservedNames[strings.ToUpper(strings.TrimSuffix(name, ext))] {
+		name = "_" + name
+	}
+
+	if len(name) > maxFilenameLength {
+		if len(ext) > 16 {
+			ext = ""
+		}
+		base := strings.TrimSuffix(name, ext)
+		base = truncateUTF8(base, maxFilenameLength-len(ext))
+		name = base + ext
+	}
+	return name
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// resolveFilename fixes the file name from the first server response, before any file is opened
+func (d *Download) resolveFilename(resp *http.Response) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if !d.NameFromServer {
+		return
+	}
+	d.NameFromServer = false
+
+	name := FilenameFromResponse(resp)
+	if name == d.Filename {
+		return
+	}
+	d.Filename = name
+	d.TargetPath = filepath.Join(filepath.Dir(d.TargetPath), name)
+}
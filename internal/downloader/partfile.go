@@ -0,0 +1,237 @@
+package downloader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mahdiXak47/Download-Manager/internal/logger"
+)
+
+// errRemoteChanged is returned when the server no longer serves the file being resumed
+var errRemoteChanged = errors.New("remote file changed since download started")
+
+// partMetadata is the sidecar stored next to a .part file describing what it holds
+type partMetadata struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	TotalSize    int64     `json:"total_size"`
+	Segments     []Segment `json:"segments,omitempty"`
+}
+
+// PartPath returns the path the download is written to until it completes
+func (d *Download) PartPath() string {
+	return d.TargetPath + ".part"
+}
+
+// metaPath returns the path of the sidecar metadata file
+func (d *Download) metaPath() string {
+	return d.TargetPath + ".part.json"
+}
+
+// saveMetadata writes the sidecar atomically so a crash never leaves it half written
+func (d *Download) saveMetadata() error {
+	d.mutex.Lock()
+	meta := partMetadata{
+		URL:          d.URL,
+		ETag:         d.ETag,
+		LastModified: d.LastModified,
+		TotalSize:    d.TotalSize,
+		Segments:     append([]Segment(nil), d.Segments...),
+	}
+	d.mutex.Unlock()
+
+	data, err := json.MarshalIndent(meta, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	tmpPath := d.metaPath() + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, d.metaPath())
+}
+
+// loadMetadata reads the sidecar of the download, if any
+func (d *Download) loadMetadata() (*partMetadata, error) {
+	data, err := os.ReadFile(d.metaPath())
+	if err != nil {
+		return nil, err
+	}
+
+	var meta partMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// removePartFiles deletes the partial file and its sidecar
+func (d *Download) removePartFiles() error {
+	if err := os.Remove(d.PartPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(d.metaPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Relocate changes TargetPath, moving any partial data and its sidecar along with it
+func (d *Download) Relocate(targetPath string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if targetPath == d.TargetPath {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	oldPart, oldMeta := d.PartPath(), d.metaPath()
+	newPart, newMeta := targetPath+".part", targetPath+".part.json"
+	if err := os.Rename(oldPart, newPart); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to move partial file: %w", err)
+	}
+	if err := os.Rename(oldMeta, newMeta); err != nil && !os.IsNotExist(err) {
+		// Put the data back so the sidecar still describes it
+		os.Rename(newPart, oldPart)
+		return fmt.Errorf("failed to move partial file metadata: %w", err)
+	}
+
+	d.TargetPath = targetPath
+	d.Filename = filepath.Base(targetPath)
+	return nil
+}
+
+// finalizePart moves the completed .part file into place and drops the sidecar
+func (d *Download) finalizePart() error {
+	if err := os.Rename(d.PartPath(), d.TargetPath); err != nil {
+		errorMsg := fmt.Sprintf("failed to move completed file into place: %v", err)
+		logger.LogDownloadError(d.URL, d.Queue, errorMsg)
+		return fmt.Errorf("failed to move completed file into place: %w", err)
+	}
+	if err := os.Remove(d.metaPath()); err != nil && !os.IsNotExist(err) {
+		logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("failed to remove metadata file: %v", err))
+	}
+	return nil
+}
+
+// resetProgress forgets any partial data, on disk and in memory
+func (d *Download) resetProgress() {
+	if err := d.removePartFiles(); err != nil {
+		logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("failed to remove partial file: %v", err))
+	}
+	d.mutex.Lock()
+	d.Downloaded = 0
+	d.Progress = 0
+	d.Segments = nil
+	d.mutex.Unlock()
+}
+
+// RecoverPartial reconciles the download state with the .part file left on disk and
+// its sidecar. It makes no requests, so startup never waits on a server; whether the
+// remote file is still the one being resumed is checked when the download resumes.
+func (d *Download) RecoverPartial() error {
+	d.Initialize()
+
+	if d.GetStatus() == "completed" {
+		return nil
+	}
+
+	info, err := os.Stat(d.PartPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			d.resetProgress()
+			return nil
+		}
+		return err
+	}
+
+	meta, err := d.loadMetadata()
+	if err != nil || meta.URL != d.URL {
+		logger.LogDownloadPending(d.URL, d.Queue, "Discarding partial file without matching metadata")
+		d.resetProgress()
+		return nil
+	}
+
+	d.mutex.Lock()
+	d.ETag = meta.ETag
+	d.LastModified = meta.LastModified
+	d.TotalSize = meta.TotalSize
+	d.Segments = meta.Segments
+	if len(d.Segments) > 0 {
+		d.Downloaded = 0
+		for _, s := range d.Segments {
+			d.Downloaded += s.Downloaded
+		}
+	} else {
+		d.Downloaded = info.Size()
+	}
+	if d.TotalSize > 0 {
+		d.Progress = float64(d.Downloaded) / float64(d.TotalSize) * 100
+	}
+	downloaded := d.Downloaded
+	d.mutex.Unlock()
+
+	logger.LogDownloadPending(d.URL, d.Queue, fmt.Sprintf("Recovered partial download at %d bytes", downloaded))
+	return nil
+}
+
+// restartFromZero discards partial data that no longer matches the remote file
+func (d *Download) restartFromZero(reason string) {
+	d.mutex.Lock()
+	status, downloaded, totalSize := d.Status, d.Downloaded, d.TotalSize
+	d.mutex.Unlock()
+
+	logger.LogDownloadStatusReason(d.URL, status, status, "restarting from zero, "+reason, downloaded, totalSize)
+	d.resetProgress()
+}
+
+// recordValidators remembers the ETag and Last-Modified headers of a response
+func (d *Download) recordValidators(resp *http.Response) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.ETag = resp.Header.Get("ETag")
+	d.LastModified = resp.Header.Get("Last-Modified")
+}
+
+// ifRangeValidator returns the value to send in If-Range, preferring a strong ETag
+func (d *Download) ifRangeValidator() string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.ETag != "" && !strings.HasPrefix(d.ETag, "W/") {
+		return d.ETag
+	}
+	return d.LastModified
+}
+
+// remoteChangeReason compares a fresh response with the validators recorded for the download
+func (d *Download) remoteChangeReason(resp *http.Response) string {
+	d.mutex.Lock()
+	etag, lastModified, totalSize := d.ETag, d.LastModified, d.TotalSize
+	d.mutex.Unlock()
+	return changeReason(etag, lastModified, totalSize, resp)
+}
+
+// changeReason describes how resp differs from the given validators, or returns "" if it doesn't
+func changeReason(etag, lastModified string, totalSize int64, resp *http.Response) string {
+	if newETag := resp.Header.Get("ETag"); newETag != "" && etag != "" && newETag != etag {
+		return fmt.Sprintf("ETag changed from %s to %s", etag, newETag)
+	}
+	if newLastModified := resp.Header.Get("Last-Modified"); newLastModified != "" && lastModified != "" && newLastModified != lastModified {
+		return fmt.Sprintf("Last-Modified changed from %s to %s", lastModified, newLastModified)
+	}
+	if size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); err == nil && totalSize > 0 && size != totalSize {
+		return fmt.Sprintf("size changed from %d to %d bytes", totalSize, size)
+	}
+	return ""
+}
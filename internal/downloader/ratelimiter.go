@@ -1,92 +1,171 @@
 package downloader
 
 import (
-	"io"
+	"context"
 	"sync"
 	"time"
 )
 
+// minBurst lets a whole read buffer through at once even at low rates
+const minBurst = 32 * 1024
+
+// RateLimiter is a token bucket computed from elapsed time rather than filled by a
+// goroutine. Wait reserves the bytes up front, letting the bucket go negative, and
+// sleeps until the reservation is paid off, so large reads are as accurate as small ones.
 type RateLimiter struct {
-	tokensPerSecond int64
-	bucketSize      int64
-	currentTokens   int64
-	lastRefill      time.Time
-	mutex           sync.Mutex
-	tokenChan       chan struct{}
-	stopChan        chan struct{}
+	mutex          sync.Mutex
+	bytesPerSecond int64
+	burst          float64       // bucket capacity in bytes
+	tokens         float64       // bytes available now; negative while reservations are outstanding
+	last           time.Time     // when tokens was last brought up to date
+	changed        chan struct{} // closed and replaced when the rate changes, to wake waiters
+	stopChan       chan struct{}
+	stopOnce       sync.Once
 }
 
+// NewRateLimiter creates a limiter allowing bytesPerSecond with a burst of a tenth of a second
 func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
-	bucketSize := bytesPerSecond
-	limiter := &RateLimiter{
-		tokensPerSecond: bytesPerSecond,
-		bucketSize:      bucketSize,
-		currentTokens:   bucketSize,
-		lastRefill:      time.Now(),
-		tokenChan:       make(chan struct{}, 1000),
-		stopChan:        make(chan struct{}),
+	burst := burstFor(bytesPerSecond)
+	return &RateLimiter{
+		bytesPerSecond: bytesPerSecond,
+		burst:          burst,
+		tokens:         burst,
+		last:           time.Now(),
+		changed:        make(chan struct{}),
+		stopChan:       make(chan struct{}),
 	}
-	go limiter.generateTokens()
-	return limiter
 }
 
-func (r *RateLimiter) generateTokens() {
-	ticker := time.NewTicker(time.Millisecond * 100)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-r.stopChan:
-			return
-		case <-ticker.C:
-			r.refillTokens()
+// burstFor returns the bucket capacity for a rate
+func burstFor(bytesPerSecond int64) float64 {
+	burst := float64(bytesPerSecond) / 10
+	if burst < minBurst {
+		burst = minBurst
+	}
+	return burst
+}
+
+// SetRate changes the rate in place. Callers already waiting recompute their wait at the new rate.
+func (r *RateLimiter) SetRate(bytesPerSecond int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if bytesPerSecond == r.bytesPerSecond {
+		return
+	}
+	r.advance(time.Now())
+	r.bytesPerSecond = bytesPerSecond
+	r.burst = burstFor(bytesPerSecond)
+	if r.tokens > r.burst {
+		r.tokens = r.burst
+	}
+	close(r.changed)
+	r.changed = make(chan struct{})
+}
+
+// Rate returns the current rate in bytes per second
+func (r *RateLimiter) Rate() int64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.bytesPerSecond
+}
+
+// advance adds the tokens earned since the last update. Callers hold the mutex.
+func (r *RateLimiter) advance(now time.Time) {
+	if elapsed := now.Sub(r.last); elapsed > 0 {
+		r.tokens += elapsed.Seconds() * float64(r.bytesPerSecond)
+		if r.tokens > r.burst {
+			r.tokens = r.burst
 		}
+		r.last = now
 	}
 }
 
-func (r *RateLimiter) refillTokens() {
+// reserve takes n bytes from the bucket and returns how long to wait before using them,
+// along with a channel that is closed if the rate changes in the meantime
+func (r *RateLimiter) reserve(n int64) (time.Duration, <-chan struct{}) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
-	now := time.Now()
-	elapsed := now.Sub(r.lastRefill).Seconds()
-	r.lastRefill = now
+	r.advance(time.Now())
+	r.tokens -= float64(n)
+	if r.tokens >= 0 {
+		return 0, nil
+	}
+	return time.Duration(-r.tokens / float64(r.bytesPerSecond) * float64(time.Second)), r.changed
+}
 
-	newTokens := int64(float64(r.tokensPerSecond) * elapsed)
-	if r.currentTokens+newTokens > r.bucketSize {
-		newTokens = r.bucketSize - r.currentTokens
+// cancel returns a reservation that won't be used
+func (r *RateLimiter) cancel(n int64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.advance(time.Now())
+	r.tokens += float64(n)
+	if r.tokens > r.burst {
+		r.tokens = r.burst
 	}
-	r.currentTokens += newTokens
+}
 
-	for r.currentTokens > 0 {
+// Wait blocks until n bytes may pass. It returns ctx.Err() if ctx ends first, giving
+// the reservation back, and nil right away once the limiter is stopped.
+func (r *RateLimiter) Wait(ctx context.Context, n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	for {
+		wait, changed := r.reserve(n)
+		if wait <= 0 {
+			return nil
+		}
+
+		timer := getTimer(wait)
 		select {
-		case r.tokenChan <- struct{}{}:
-			r.currentTokens--
-		default:
-			return
+		case <-timer.C:
+			timerPool.Put(timer)
+			return nil
+		case <-changed:
+			// Give the reservation back and take it again at the new rate
+			putTimer(timer)
+			r.cancel(n)
+		case <-r.stopChan:
+			// The limit was removed, stop waiting on it
+			putTimer(timer)
+			return nil
+		case <-ctx.Done():
+			putTimer(timer)
+			r.cancel(n)
+			return ctx.Err()
 		}
 	}
 }
 
-func (r *RateLimiter) GetToken(bytes int64) {
-	tokensNeeded := bytes
-	for tokensNeeded > 0 {
-		<-r.tokenChan
-		tokensNeeded--
+// timerPool reuses the timers Wait sleeps on, so waiting does not allocate. Many
+// callers wait on one limiter at once, so a single timer per limiter would not do.
+var timerPool sync.Pool
+
+// getTimer returns a stopped, drained timer from the pool set to fire after d
+func getTimer(d time.Duration) *time.Timer {
+	if timer, ok := timerPool.Get().(*time.Timer); ok {
+		timer.Reset(d)
+		return timer
 	}
+	return time.NewTimer(d)
 }
 
-// Read reads data from reader and applies rate limiting
-// It implements a rate-limited reader for the download process
-func (r *RateLimiter) Read(reader io.Reader, buffer []byte) (int, error) {
-	// First read data from the original reader
-	n, err := reader.Read(buffer)
-	if n > 0 {
-		// If data was read, limit the rate by getting tokens
-		r.GetToken(int64(n))
+// putTimer stops a timer that has not been received from and returns it to the pool
+func putTimer(timer *time.Timer) {
+	if !timer.Stop() {
+		// It fired before it was stopped, so drain the channel for the next user
+		select {
+		case <-timer.C:
+		default:
+		}
 	}
-	return n, err
+	timerPool.Put(timer)
 }
 
+// Stop releases anyone waiting on the limiter
 func (r *RateLimiter) Stop() {
-	close(r.stopChan)
+	r.stopOnce.Do(func() { close(r.stopChan) })
 }
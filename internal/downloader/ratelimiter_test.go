@@ -0,0 +1,189 @@
+package downloader
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// consume waits for total bytes in chunks of chunk and returns how long it took
+func consume(r *RateLimiter, total, chunk int64) time.Duration {
+	start := time.Now()
+	for sent := int64(0); sent < total; sent += chunk {
+		r.Wait(context.Background(), chunk)
+	}
+	return time.Since(start)
+}
+
+// within reports whether got is within tolerance of want
+func within(got, want time.Duration, tolerance float64) bool {
+	diff := float64(got - want)
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= float64(want)*tolerance
+}
+
+func TestRateLimiterSteadyRate(t *testing.T) {
+	const rate = 4 << 20 // 4 MB/s, a 400 KB burst
+	r := NewRateLimiter(rate)
+
+	// Spend the burst first so only the steady rate is measured
+	r.Wait(context.Background(), int64(r.burst))
+
+	elapsed := consume(r, rate/2, 32*1024)
+	if want := 500 * time.Millisecond; !within(elapsed, want, 0.1) {
+		t.Errorf("2 MB at 4 MB/s took %v, want about %v", elapsed, want)
+	}
+}
+
+func TestRateLimiterLargeReads(t *testing.T) {
+	const rate = 1 << 20
+	r := NewRateLimiter(rate)
+	r.Wait(context.Background(), int64(r.burst))
+
+	// Reads larger than the burst reserve ahead rather than slipping through
+	elapsed := consume(r, rate/2, 256*1024)
+	if want := 500 * time.Millisecond; !within(elapsed, want, 0.1) {
+		t.Errorf("512 KB in 256 KB reads at 1 MB/s took %v, want about %v", elapsed, want)
+	}
+}
+
+func TestRateLimiterBurst(t *testing.T) {
+	const rate = 10 << 20 // a 1 MB burst
+	r := NewRateLimiter(rate)
+
+	// A fresh limiter lets a full burst through at once
+	if elapsed := consume(r, int64(r.burst), 32*1024); elapsed > 20*time.Millisecond {
+		t.Errorf("burst of %v bytes took %v, want no wait", r.burst, elapsed)
+	}
+
+	// Anything beyond it is paced at the rate
+	elapsed := consume(r, rate/5, 32*1024)
+	if want := 200 * time.Millisecond; !within(elapsed, want, 0.15) {
+		t.Errorf("2 MB after the burst took %v, want about %v", elapsed, want)
+	}
+
+	// Idle time refills the bucket, but never past the burst
+	time.Sleep(300 * time.Millisecond)
+	r.mutex.Lock()
+	r.advance(time.Now())
+	tokens := r.tokens
+	r.mutex.Unlock()
+	if tokens != r.burst {
+		t.Errorf("tokens after idling = %v, want the burst of %v", tokens, r.burst)
+	}
+}
+
+func TestRateLimiterSharedRate(t *testing.T) {
+	const rate = 4 << 20
+	r := NewRateLimiter(rate)
+	r.Wait(context.Background(), int64(r.burst))
+
+	// Four callers together get the limiter's rate, not four times it
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			consume(r, rate/8, 32*1024)
+		}()
+	}
+	wg.Wait()
+	if elapsed, want := time.Since(start), 500*time.Millisecond; !within(elapsed, want, 0.1) {
+		t.Errorf("4 callers sending 2 MB at 4 MB/s took %v, want about %v", elapsed, want)
+	}
+}
+
+func TestRateLimiterSetRate(t *testing.T) {
+	r := NewRateLimiter(1024)
+	r.Wait(context.Background(), int64(r.burst))
+
+	// A waiter at the old rate is woken and recomputes its wait at the new one
+	done := make(chan time.Duration)
+	go func() { done <- consume(r, 64*1024, 64*1024) }()
+	time.Sleep(50 * time.Millisecond)
+	r.SetRate(1 << 20)
+
+	if elapsed := <-done; elapsed > 200*time.Millisecond {
+		t.Errorf("wait took %v after raising the rate, want about 60ms", elapsed)
+	}
+}
+
+func TestRateLimiterWaitCancelled(t *testing.T) {
+	r := NewRateLimiter(1024)
+	r.Wait(context.Background(), int64(r.burst))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := r.Wait(ctx, 64*1024); err != context.DeadlineExceeded {
+		t.Errorf("Wait returned %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("cancelled Wait took %v", elapsed)
+	}
+
+	// The abandoned reservation is given back
+	r.mutex.Lock()
+	r.advance(time.Now())
+	tokens := r.tokens
+	r.mutex.Unlock()
+	if tokens < 0 {
+		t.Errorf("tokens = %v after a cancelled wait, want the reservation returned", tokens)
+	}
+}
+
+func TestRateLimiterWaitAllocs(t *testing.T) {
+	r := NewRateLimiter(64 << 20)
+	r.Wait(context.Background(), int64(r.burst))
+	r.Wait(context.Background(), 32*1024) // warm the timer pool
+
+	allocs := testing.AllocsPerRun(100, func() {
+		r.Wait(context.Background(), 32*1024)
+	})
+	if allocs != 0 {
+		t.Errorf("a blocking Wait allocated %v times, want 0", allocs)
+	}
+}
+
+func BenchmarkRateLimiterWait(b *testing.B) {
+	// Fast enough that the bucket never runs dry: the cost of the bookkeeping alone
+	r := NewRateLimiter(1 << 50)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Wait(ctx, 32*1024)
+	}
+}
+
+func BenchmarkRateLimiterWaitBlocking(b *testing.B) {
+	// Every 32 KB read waits about 30µs, exercising the timer path
+	r := NewRateLimiter(1 << 30)
+	ctx := context.Background()
+	r.Wait(ctx, int64(r.burst))
+	b.ReportAllocs()
+	b.SetBytes(32 * 1024)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Wait(ctx, 32*1024)
+	}
+}
+
+func BenchmarkRateLimiterWaitParallel(b *testing.B) {
+	// Many downloads drawing on one shared queue or global limiter
+	r := NewRateLimiter(1 << 30)
+	ctx := context.Background()
+	r.Wait(ctx, int64(r.burst))
+	b.ReportAllocs()
+	b.SetBytes(32 * 1024)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r.Wait(ctx, 32*1024)
+		}
+	})
+}
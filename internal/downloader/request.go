@@ -0,0 +1,139 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mahdiXak47/Download-Manager/internal/auth"
+)
+
+// DefaultUserAgent identifies the download manager when no User-Agent is configured
+const DefaultUserAgent = "Download-Manager/0.1"
+
+// newRequest builds a request carrying the download's User-Agent, custom headers and
+// any stored credentials for the host. Cookies from CookieFile are added by the client's jar.
+func (d *Download) newRequest(ctx context.Context, method, rawURL string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	userAgent := d.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+	for name, value := range d.Headers {
+		req.Header.Set(name, value)
+	}
+	auth.Apply(req)
+	return req, nil
+}
+
+// do sends req, answering a Digest authentication challenge once if the server asks for one
+func (d *Download) do(req *http.Request) (*http.Response, error) {
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if auth.Challenge(req, resp) {
+		resp.Body.Close()
+		return d.client.Do(req)
+	}
+	return resp, nil
+}
+
+// head sends a HEAD request for the download URL
+func (d *Download) head(ctx context.Context) (*http.Response, error) {
+	req, err := d.newRequest(ctx, "HEAD", d.URL)
+	if err != nil {
+		return nil, err
+	}
+	return d.do(req)
+}
+
+// ParseHeaders parses headers written as "Name: value | Other: value"
+func ParseHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, field := range strings.Split(s, "|") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		name, value, found := strings.Cut(field, ":")
+		name = strings.TrimSpace(name)
+		if !found || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid header %q, expected \"Name: value\"", field)
+		}
+		headers[http.CanonicalHeaderKey(name)] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+// loadCookieJar reads a Netscape/curl style cookies.txt file into a cookie jar
+func loadCookieJar(path string) (http.CookieJar, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cookie file: %w", err)
+	}
+	defer file.Close()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		// curl marks HttpOnly cookies with a prefix on an otherwise commented line
+		httpOnly := strings.HasPrefix(line, "#HttpOnly_")
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			continue
+		}
+
+		domain := fields[0]
+		cookie := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HttpOnly: httpOnly,
+		}
+		if strings.EqualFold(fields[1], "TRUE") {
+			cookie.Domain = domain
+		}
+		if expires, err := strconv.ParseInt(fields[4], 10, 64); err == nil && expires > 0 {
+			cookie.Expires = time.Unix(expires, 0)
+		}
+
+		scheme := "http"
+		if cookie.Secure {
+			scheme = "https"
+		}
+		host := strings.TrimPrefix(domain, ".")
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: cookie.Path}, []*http.Cookie{cookie})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read cookie file: %w", err)
+	}
+	return jar, nil
+}
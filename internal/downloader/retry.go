@@ -0,0 +1,197 @@
+package downloader
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy decides whether and when a failed download is tried again. Zero fields
+// take their value from DefaultRetryPolicy.
+type RetryPolicy struct {
+	MaxAttempts int     `json:"max_attempts,omitempty"` // tries in total, including the first
+	BaseDelay   float64 `json:"base_delay,omitempty"`   // seconds before the first retry, doubling after each
+	MaxDelay    float64 `json:"max_delay,omitempty"`    // seconds the doubling stops at
+	Jitter      float64 `json:"jitter,omitempty"`       // fraction of the delay to randomise by, 0 to 1
+}
+
+// DefaultRetryPolicy tries a download four times, waiting 5s, 10s and 20s in between
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 4, BaseDelay: 5, MaxDelay: 300, Jitter: 0.2}
+
+// maxAttemptHistory bounds how many failed attempts a download remembers
+const maxAttemptHistory = 10
+
+// withDefaults fills in the fields left at zero
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		p.Jitter = DefaultRetryPolicy.Jitter
+	}
+	return p
+}
+
+// Delay returns how long to wait before retry number retry (1 for the first). A
+// server's Retry-After is honoured; otherwise the delay doubles from BaseDelay and is
+// spread by Jitter so downloads don't retry in step. Either way it stops at MaxDelay,
+// so a server can't hold a download back for longer than the policy allows.
+func (p RetryPolicy) Delay(retry int, retryAfter time.Duration) time.Duration {
+	p = p.withDefaults()
+	maxDelay := time.Duration(p.MaxDelay * float64(time.Second))
+	if retryAfter > 0 {
+		return min(retryAfter, maxDelay)
+	}
+
+	seconds := p.BaseDelay * math.Pow(2, float64(retry-1))
+	seconds *= 1 + p.Jitter*(2*rand.Float64()-1)
+	return min(time.Duration(seconds*float64(time.Second)), maxDelay)
+}
+
+// ErrorClass is the kind of failure behind a download error
+type ErrorClass string
+
+const (
+	ErrorDNS       ErrorClass = "dns"       // host name lookup failed
+	ErrorConnect   ErrorClass = "connect"   // connection refused or unreachable
+	ErrorTLS       ErrorClass = "tls"       // certificate or handshake failure
+	ErrorTimeout   ErrorClass = "timeout"   // no response or data in time
+	ErrorNetwork   ErrorClass = "network"   // connection dropped mid-transfer
+	ErrorClient    ErrorClass = "client"    // 4xx response other than 408 and 429
+	ErrorThrottled ErrorClass = "throttled" // 429 or 503, possibly with Retry-After
+	ErrorServer    ErrorClass = "server"    // other 5xx response
+	ErrorDiskFull  ErrorClass = "disk-full" // no space left on the device
+	ErrorFile      ErrorClass = "file"      // other local file error, such as permissions
+	ErrorOther     ErrorClass = "other"
+)
+
+// StatusError is an unsuccessful HTTP response
+type StatusError struct {
+	StatusCode int
+	Status     string
+	RetryAfter time.Duration // from the Retry-After header, 0 if absent
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server responded with status: %s", e.Status)
+}
+
+// newStatusError describes an unsuccessful response
+func newStatusError(resp *http.Response) *StatusError {
+	return &StatusError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// ClassifyError returns the kind of failure behind err, whether trying again may
+// help, and how long the server asked to wait first
+func ClassifyError(err error) (class ErrorClass, retryable bool, retryAfter time.Duration) {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch code := statusErr.StatusCode; {
+		case code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable:
+			return ErrorThrottled, true, statusErr.RetryAfter
+		case code == http.StatusRequestTimeout:
+			return ErrorTimeout, true, 0
+		case code >= 500:
+			return ErrorServer, true, statusErr.RetryAfter
+		default:
+			return ErrorClient, false, 0
+		}
+	}
+
+	if errors.Is(err, syscall.ENOSPC) {
+		return ErrorDiskFull, false, 0
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		// A name that doesn't exist won't start to by waiting
+		return ErrorDNS, !dnsErr.IsNotFound, 0
+	}
+
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCert x509.CertificateInvalidError
+	var recordErr tls.RecordHeaderError
+	if errors.As(err, &certErr) || errors.As(err, &unknownAuthority) || errors.As(err, &hostnameErr) ||
+		errors.As(err, &invalidCert) || errors.As(err, &recordErr) {
+		return ErrorTLS, false, 0
+	}
+
+	if os.IsTimeout(err) || errors.Is(err, errStalled) {
+		return ErrorTimeout, true, 0
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return ErrorConnect, true, 0
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.As(err, &opErr) {
+		return ErrorNetwork, true, 0
+	}
+
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return ErrorFile, false, 0
+	}
+
+	// Unknown failures, such as a short body, are worth another try
+	return ErrorOther, true, 0
+}
+
+// Attempt records a failed try of a download
+type Attempt struct {
+	Time      time.Time  `json:"time"`
+	Cause     ErrorClass `json:"cause"`
+	Error     string     `json:"error"`
+	Retryable bool       `json:"retryable"`
+}
+
+// recordAttempt remembers a failed try, keeping only the most recent ones. Callers hold the mutex.
+func (d *Download) recordAttempt(err error, cause ErrorClass, retryable bool) {
+	d.Attempts = append(d.Attempts, Attempt{Time: time.Now(), Cause: cause, Error: err.Error(), Retryable: retryable})
+	if len(d.Attempts) > maxAttemptHistory {
+		d.Attempts = d.Attempts[len(d.Attempts)-maxAttemptHistory:]
+	}
+}
@@ -0,0 +1,306 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mahdiXak47/Download-Manager/internal/logger"
+)
+
+// minSegmentSize is the smallest byte range worth opening a separate connection for
+const minSegmentSize = 1024 * 1024
+
+// Segment represents one byte range of a multi-connection download
+type Segment struct {
+	Index      int   `json:"index"`
+	Start      int64 `json:"start"`
+	End        int64 `json:"end"` // inclusive
+	Downloaded int64 `json:"downloaded"`
+}
+
+// Size returns the number of bytes covered by the segment
+func (s *Segment) Size() int64 {
+	return s.End - s.Start + 1
+}
+
+// Done reports whether every byte of the segment has been written
+func (s *Segment) Done() bool {
+	return s.Downloaded >= s.Size()
+}
+
+// splitSegments divides totalSize bytes into at most count contiguous ranges
+func splitSegments(totalSize int64, count int) []Segment {
+	if int64(count) > totalSize/minSegmentSize {
+		count = int(totalSize / minSegmentSize)
+	}
+	if count < 1 {
+		count = 1
+	}
+
+	segments := make([]Segment, count)
+	segmentSize := totalSize / int64(count)
+	for i := range segments {
+		segments[i] = Segment{
+			Index: i,
+			Start: int64(i) * segmentSize,
+			End:   int64(i+1)*segmentSize - 1,
+		}
+	}
+	segments[count-1].End = totalSize - 1
+	return segments
+}
+
+// useSegments reports whether a download of totalSize bytes should be split
+func (d *Download) useSegments(totalSize int64, supportsRanges bool) bool {
+	return d.SegmentCount > 1 && supportsRanges && totalSize >= 2*minSegmentSize
+}
+
+// downloadSegmented fetches the file as parallel byte ranges and writes them into TargetPath
+func (d *Download) downloadSegmented(ctx context.Context, totalSize int64) error {
+	_, statErr := os.Stat(d.PartPath())
+
+	d.mutex.Lock()
+	resuming := statErr == nil && len(d.Segments) > 0 && d.TotalSize == totalSize
+	if !resuming {
+		d.Downloaded = 0
+		d.Segments = splitSegments(totalSize, d.SegmentCount)
+	}
+	d.TotalSize = totalSize
+	segmentCount := len(d.Segments)
+	d.mutex.Unlock()
+
+	openMode := os.O_CREATE | os.O_WRONLY
+	if !resuming {
+		openMode |= os.O_TRUNC
+
+		// A fresh download must not clobber an existing file unless the policy says so
+		skip, err := d.claimTarget(totalSize)
+		if err != nil {
+			return err
+		}
+		if skip {
+			return nil
+		}
+	}
+	file, err := os.OpenFile(d.PartPath(), openMode, 0644)
+	if err != nil {
+		errorMsg := fmt.Sprintf("failed to open file: %v", err)
+		logger.LogDownloadError(d.URL, d.Queue, errorMsg)
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	// Reserve the full size up front so every segment can write at its own offset
+	if err := file.Truncate(totalSize); err != nil {
+		errorMsg := fmt.Sprintf("failed to allocate file: %v", err)
+		logger.LogDownloadError(d.URL, d.Queue, errorMsg)
+		return fmt.Errorf("failed to allocate file: %w", err)
+	}
+
+	if err := d.saveMetadata(); err != nil {
+		logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("failed to save metadata: %v", err))
+	}
+
+	logger.LogDownloadPending(d.URL, d.Queue, fmt.Sprintf("Downloading in %d segments", segmentCount))
+
+	// The first segment to fail stops the others
+	ctx, abort := context.WithCancelCause(ctx)
+	defer abort(nil)
+
+	limiter := d.newThrottle(ctx) // Shared by all segments
+
+	errs := make(chan error, segmentCount)
+	var wg sync.WaitGroup
+	for i := 0; i < segmentCount; i++ {
+		d.mutex.Lock()
+		done := d.Segments[i].Done()
+		d.mutex.Unlock()
+		if done {
+			continue
+		}
+
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			for {
+				before := d.segmentDownloaded(index)
+				err := d.fetchSegment(ctx, index, file, limiter)
+
+				// A stalled connection that had been delivering is reopened where it stopped,
+				// without failing the attempt
+				if errors.Is(err, errStalled) && d.segmentDownloaded(index) > before {
+					logger.LogDownloadPending(d.URL, d.Queue, fmt.Sprintf("%v, reconnecting", err))
+					continue
+				}
+				if err != nil {
+					errs <- err
+					abort(err)
+				}
+				return
+			}
+		}(i)
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	d.trackSegmentSpeed(finished, file, totalSize)
+
+	close(errs)
+	d.syncSegments(file)
+
+	if err := <-errs; err != nil {
+		if errors.Is(err, errRemoteChanged) {
+			d.restartFromZero("server reported the file changed (If-Range)")
+		}
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %w", err)
+	}
+	// Segments arrive out of order, so the checksum is computed from the assembled file
+	if err := d.finishPart(nil); err != nil {
+		return err
+	}
+
+	d.mutex.Lock()
+	d.Downloaded = totalSize
+	d.Progress = 100.0
+	d.mutex.Unlock()
+	return nil
+}
+
+// fetchSegment downloads the remaining bytes of one segment
+func (d *Download) fetchSegment(ctx context.Context, index int, file *os.File, limiter *throttle) error {
+	d.mutex.Lock()
+	segment := d.Segments[index]
+	d.mutex.Unlock()
+
+	offset := segment.Start + segment.Downloaded
+	req, err := d.newRequest(ctx, "GET", d.URL)
+	if err != nil {
+		return fmt.Errorf("segment %d: failed to create request: %w", index, err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, segment.End))
+	if validator := d.ifRangeValidator(); validator != "" {
+		req.Header.Set("If-Range", validator)
+	}
+
+	resp, err := d.doWatched(req)
+	if err != nil {
+		return fmt.Errorf("segment %d: failed to send GET request: %w", index, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK && req.Header.Get("If-Range") != "" {
+		d.recordValidators(resp)
+		return errRemoteChanged
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("segment %d: %w", index, newStatusError(resp))
+		}
+		return fmt.Errorf("segment %d: server responded with status: %s", index, resp.Status)
+	}
+
+	writer := io.NewOffsetWriter(file, offset)
+	buffer := make([]byte, 32*1024)
+	remaining := segment.End - offset + 1
+
+	for remaining > 0 {
+		chunk := buffer
+		if int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+
+		var n int
+		n, err = limiter.Read(resp.Body, chunk)
+
+		if n > 0 {
+			if _, werr := writer.Write(chunk[:n]); werr != nil {
+				return fmt.Errorf("segment %d: error writing to file: %w", index, werr)
+			}
+			remaining -= int64(n)
+
+			d.mutex.Lock()
+			d.Segments[index].Downloaded += int64(n)
+			d.Downloaded += int64(n)
+			if d.TotalSize > 0 {
+				d.Progress = float64(d.Downloaded) / float64(d.TotalSize) * 100
+			}
+			d.mutex.Unlock()
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("segment %d: error reading from response: %w", index, err)
+		}
+	}
+
+	if remaining > 0 {
+		return fmt.Errorf("segment %d: incomplete, %d bytes missing", index, remaining)
+	}
+	return nil
+}
+
+// segmentDownloaded returns how many bytes of a segment have been written
+func (d *Download) segmentDownloaded(index int) int64 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.Segments[index].Downloaded
+}
+
+// trackSegmentSpeed updates Speed and checkpoints the segment map once a second
+// until all segment workers have finished
+func (d *Download) trackSegmentSpeed(finished <-chan struct{}, file *os.File, totalSize int64) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	d.mutex.Lock()
+	lastBytes := d.Downloaded
+	d.mutex.Unlock()
+	lastUpdateTime := time.Now()
+
+	for {
+		select {
+		case <-finished:
+			return
+		case now := <-ticker.C:
+			d.mutex.Lock()
+			downloaded := d.Downloaded
+			d.Speed = int64(float64(downloaded-lastBytes) / now.Sub(lastUpdateTime).Seconds())
+			d.mutex.Unlock()
+
+			if int(downloaded*10/totalSize) > int(lastBytes*10/totalSize) {
+				logger.LogDownloadStatus(d.URL, "downloading", "downloading", downloaded, totalSize)
+			}
+			lastBytes = downloaded
+			lastUpdateTime = now
+			d.syncSegments(file)
+		}
+	}
+}
+
+// syncSegments flushes written data before recording it in the sidecar, so the
+// segment map never claims bytes that are not on disk
+func (d *Download) syncSegments(file *os.File) {
+	if err := file.Sync(); err != nil {
+		logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("failed to sync partial file: %v", err))
+		return
+	}
+	if err := d.saveMetadata(); err != nil {
+		logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("failed to save metadata: %v", err))
+	}
+}
@@ -0,0 +1,154 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/mahdiXak47/Download-Manager/internal/logger"
+)
+
+// StallPolicy decides when a connection that has stopped delivering is dropped and
+// reopened from the current offset. Only time spent waiting on the server counts,
+// not time held back by a bandwidth limit. Zero fields take their value from
+// DefaultStallPolicy.
+type StallPolicy struct {
+	Timeout     float64 `json:"timeout,omitempty"`       // seconds without a byte before reconnecting, negative for never
+	MinSpeed    int64   `json:"min_speed,omitempty"`     // KB/s a connection must keep up, 0 for no floor
+	MinSpeedFor float64 `json:"min_speed_for,omitempty"` // seconds below MinSpeed before reconnecting
+}
+
+// DefaultStallPolicy reconnects after 30 seconds without data and sets no speed floor
+var DefaultStallPolicy = StallPolicy{Timeout: 30, MinSpeedFor: 30}
+
+// errStalled is the cause a connection is aborted with when it stalls
+var errStalled = errors.New("connection stalled")
+
+// withDefaults fills in the fields left at zero
+func (p StallPolicy) withDefaults() StallPolicy {
+	if p.Timeout == 0 {
+		p.Timeout = DefaultStallPolicy.Timeout
+	}
+	if p.MinSpeedFor <= 0 {
+		p.MinSpeedFor = DefaultStallPolicy.MinSpeedFor
+	}
+	return p
+}
+
+// toDuration converts a policy field in seconds to a duration
+func toDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// watchedBody is a response body that aborts its connection when the server stops
+// sending, or sends too slowly, under the download's StallPolicy
+type watchedBody struct {
+	body   io.ReadCloser
+	ctx    context.Context
+	abort  context.CancelCauseFunc
+	policy StallPolicy
+	timer  *time.Timer // runs only while a read waits on the server
+
+	windowTime  time.Duration // time spent in reads since the speed window began
+	windowBytes int64
+}
+
+// doWatched sends req like do, watching the response body for stalls
+func (d *Download) doWatched(req *http.Request) (*http.Response, error) {
+	ctx, abort := context.WithCancelCause(req.Context())
+	resp, err := d.do(req.WithContext(ctx))
+	if err != nil {
+		abort(nil)
+		return nil, err
+	}
+
+	d.mutex.Lock()
+	policy := d.StallPolicy.withDefaults()
+	d.mutex.Unlock()
+
+	w := &watchedBody{body: resp.Body, ctx: ctx, abort: abort, policy: policy}
+	if policy.Timeout > 0 {
+		timeout := toDuration(policy.Timeout)
+		w.timer = time.AfterFunc(timeout, func() {
+			abort(fmt.Errorf("%w: no data for %s", errStalled, timeout))
+		})
+		w.timer.Stop()
+	}
+	resp.Body = w
+	return resp, nil
+}
+
+func (w *watchedBody) Read(p []byte) (int, error) {
+	// The speed floor aborts after handing over the bytes of the read that tripped it
+	if cause := context.Cause(w.ctx); errors.Is(cause, errStalled) {
+		return 0, cause
+	}
+	if w.timer != nil {
+		w.timer.Reset(toDuration(w.policy.Timeout))
+	}
+	started := time.Now()
+	n, err := w.body.Read(p)
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+
+	if err != nil {
+		if cause := context.Cause(w.ctx); errors.Is(cause, errStalled) {
+			return n, cause
+		}
+		return n, err
+	}
+
+	if w.policy.MinSpeed > 0 {
+		w.windowTime += time.Since(started)
+		w.windowBytes += int64(n)
+		if window := toDuration(w.policy.MinSpeedFor); w.windowTime >= window {
+			speed := float64(w.windowBytes) / w.windowTime.Seconds()
+			w.windowTime, w.windowBytes = 0, 0
+			if speed < float64(w.policy.MinSpeed*1024) {
+				cause := fmt.Errorf("%w: below %d KB/s for %s", errStalled, w.policy.MinSpeed, window)
+				w.abort(cause)
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *watchedBody) Close() error {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.abort(nil)
+	return w.body.Close()
+}
+
+// reconnect reopens a stalled download from offset. The server must answer with the
+// remaining bytes of the same file, or the attempt fails as usual.
+func (d *Download) reconnect(ctx context.Context, offset int64, stall error) (io.ReadCloser, error) {
+	logger.LogDownloadPending(d.URL, d.Queue, fmt.Sprintf("%v, reconnecting from byte %d", stall, offset))
+
+	req, err := d.newRequest(ctx, "GET", d.URL)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	if validator := d.ifRangeValidator(); validator != "" {
+		req.Header.Set("If-Range", validator)
+	}
+
+	resp, err := d.doWatched(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return nil, newStatusError(resp)
+		}
+		return nil, fmt.Errorf("server did not resume from byte %d (status: %s)", offset, resp.Status)
+	}
+	return resp.Body, nil
+}
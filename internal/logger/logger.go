@@ -116,6 +116,13 @@ func LogDownloadStatus(url, oldStatus, newStatus string, downloadedBytes, totalB
 	logDownloadEvent("STATUS", message)
 }
 
+// LogDownloadStatusReason logs a status change together with what caused it
+func LogDownloadStatusReason(url, oldStatus, newStatus, reason string, downloadedBytes, totalBytes int64) {
+	message := fmt.Sprintf("Status changed for %s: %s -> %s (Reason: %s, Downloaded: %d/%d bytes)",
+		url, oldStatus, newStatus, reason, downloadedBytes, totalBytes)
+	logDownloadEvent("STATUS", message)
+}
+
 // LogDownloadError logs download errors
 func LogDownloadError(url, queue, errorMsg string) {
 	message := fmt.Sprintf("Error for download %s in queue %s: %s", url, queue, errorMsg)
@@ -0,0 +1,124 @@
+package queue
+
+import (
+	"fmt"
+
+	"github.com/mahdiXak47/Download-Manager/internal/config"
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
+	"github.com/mahdiXak47/Download-Manager/internal/logger"
+)
+
+// A download with DependsOn waits in pending until every prerequisite has completed.
+// If one fails it is marked blocked, and so is everything waiting on it in turn; it
+// goes back to pending when the prerequisite is retried. Prerequisites that have been
+// removed from the list no longer hold anything up.
+
+// prerequisiteFailed reports whether a prerequisite in this status will not complete
+// without the user's help
+func prerequisiteFailed(status string) bool {
+	switch status {
+	case "error", "verify-failed", "cancelled", "blocked":
+		return true
+	}
+	return false
+}
+
+// dependencyState reports whether all of d's prerequisites have completed, and the URL
+// of the first one that failed, if any
+func (m *Manager) dependencyState(d *downloader.Download) (ready bool, failed string) {
+	ready = true
+	for _, url := range d.DependsOn {
+		prerequisite := m.findDownload(url)
+		if prerequisite == nil {
+			continue
+		}
+		status := prerequisite.GetStatus()
+		if prerequisiteFailed(status) {
+			return false, url
+		}
+		if status != "completed" {
+			ready = false
+		}
+	}
+	return ready, ""
+}
+
+// updateBlocked marks pending downloads with a failed prerequisite as blocked, and
+// returns blocked downloads to pending once none of their prerequisites has failed.
+// It repeats until nothing changes so a failure cascades down a chain.
+func (m *Manager) updateBlocked() {
+	for changed := true; changed; {
+		changed = false
+		for i := range m.config.Downloads {
+			d := &m.config.Downloads[i]
+			if len(d.DependsOn) == 0 {
+				continue
+			}
+
+			_, failed := m.dependencyState(d)
+			switch {
+			case d.Status == "pending" && failed != "":
+				d.Status = "blocked"
+				d.Error = fmt.Sprintf("prerequisite %s did not complete", failed)
+				logger.LogDownloadPending(d.URL, d.Queue, fmt.Sprintf("Blocked: %s", d.Error))
+				changed = true
+			case d.Status == "blocked" && failed == "":
+				d.Status = "pending"
+				d.Error = ""
+				logger.LogDownloadPending(d.URL, d.Queue, "Unblocked: prerequisites are no longer failed")
+				changed = true
+			}
+		}
+	}
+}
+
+// SetDependencies makes a download wait for the downloads with the given URLs. It
+// refuses prerequisites that are unknown or that would wait on the download itself.
+func (m *Manager) SetDependencies(url string, dependsOn []string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	d := m.findDownload(url)
+	if d == nil {
+		return fmt.Errorf("download %s not found", url)
+	}
+	for _, prerequisite := range dependsOn {
+		if m.findDownload(prerequisite) == nil {
+			return fmt.Errorf("prerequisite %s not found", prerequisite)
+		}
+		if m.dependsOn(prerequisite, url, make(map[string]bool)) {
+			return fmt.Errorf("%s already waits for %s", prerequisite, url)
+		}
+	}
+
+	d.DependsOn = dependsOn
+	m.updateBlocked()
+	if err := config.SaveConfig(m.config); err != nil {
+		logger.LogDownloadError(url, d.Queue, fmt.Sprintf("Failed to save config when setting prerequisites: %v", err))
+	}
+	m.notify()
+	return nil
+}
+
+// dependsOn reports whether the download with URL from waits, directly or through
+// other downloads, for the one with URL on
+func (m *Manager) dependsOn(from, on string, visited map[string]bool) bool {
+	if from == on {
+		return true
+	}
+	if visited[from] {
+		return false
+	}
+	visited[from] = true
+
+	d := m.findDownload(from)
+	if d == nil {
+		return false
+	}
+	for _, prerequisite := range d.DependsOn {
+		if m.dependsOn(prerequisite, on, visited) {
+			return true
+		}
+	}
+	return false
+}
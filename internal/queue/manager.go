@@ -1,8 +1,11 @@
 package queue
 
 import (
+	"context"
 	"fmt"
 	"net/url"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -14,35 +17,109 @@ import (
 	"github.com/mahdiXak47/Download-Manager/internal/logger"
 )
 
+const (
+	// usageInterval is how often downloaded bytes are counted against queue quotas
+	usageInterval = 2 * time.Second
+	// usageSaveInterval is how often the counts are saved while downloads run
+	usageSaveInterval = 30 * time.Second
+)
+
 type Manager struct {
 	config     *config.Config
 	activeJobs map[string]int                  // queue name -> active download count
+	active     map[string]string               // URL -> queue, for the downloads counted in activeJobs
+	running    map[string]bool                 // URLs whose Start has not returned yet
 	downloads  map[string]*downloader.Download // URL -> Download for quick lookup
-	mutex      sync.Mutex
-	ticker     *time.Ticker
+	// URLs paused by the manager, because their queue's window closed or a resume had
+	// to wait for a free slot, as opposed to by the user; only these resume on their own
+	pausedBySchedule map[string]bool
+	lastServed       string           // queue that got the last slot, so the next pass starts after it
+	lastDownloaded   map[string]int64 // URL -> Downloaded when its usage was last counted
+	lastUsageSave    time.Time
+	mutex            sync.Mutex
+	wake             chan struct{} // asks the run loop to process queues now
+	stop             chan struct{}
+	stopOnce         sync.Once
 }
 
 func NewManager(cfg *config.Config) *Manager {
 	m := &Manager{
-		config:     cfg,
-		activeJobs: make(map[string]int),
-		downloads:  make(map[string]*downloader.Download),
-		ticker:     time.NewTicker(10 * time.Second),
+		config:           cfg,
+		activeJobs:       make(map[string]int),
+		active:           make(map[string]string),
+		running:          make(map[string]bool),
+		pausedBySchedule: make(map[string]bool),
+		lastDownloaded:   make(map[string]int64),
+		downloads:        make(map[string]*downloader.Download),
+		wake:             make(chan struct{}, 1),
+		stop:             make(chan struct{}),
 	}
 
 	// Initialize existing downloads
 	for i := range cfg.Downloads {
 		d := &cfg.Downloads[i]
 		m.downloads[d.URL] = d
+
+		// Nothing is running yet, so anything left downloading by the last run starts over
+		// from the queue. A paused download stays paused and is started again when resumed.
 		if d.Status == "downloading" {
-			m.activeJobs[d.Queue]++
+			d.Status = "pending"
+		}
+
+		// Pick up where the bytes on disk end
+		if err := d.RecoverPartial(); err != nil {
+			logger.LogDownloadError(d.URL, d.Queue, fmt.Sprintf("Failed to recover partial download: %v", err))
 		}
 	}
 
+	m.applyBandwidthLimits()
+
 	logger.LogDownloadEvent("SYSTEM", fmt.Sprintf("Queue Manager initialized with %d downloads", len(cfg.Downloads)))
 	return m
 }
 
+// applyBandwidthLimits sets the global and per-queue bandwidth caps from the config,
+// following each queue's bandwidth schedule
+func (m *Manager) applyBandwidthLimits() {
+	now := time.Now()
+	downloader.SetGlobalBandwidth(m.config.SpeedLimit)
+	for _, q := range m.config.Queues {
+		downloader.SetQueueBandwidth(q.Name, q.EffectiveSpeedLimit(now))
+	}
+}
+
+// ApplyQueueSettings pushes a queue's edited settings to the downloads running in it.
+// Bandwidth limits change in place, so active downloads pick them up on their next read,
+// and a changed proxy is used from their next connection.
+func (m *Manager) ApplyQueueSettings(name string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	q := m.config.GetQueue(name)
+	if q == nil {
+		return
+	}
+	speedLimit := q.EffectiveSpeedLimit(time.Now())
+	downloader.SetQueueBandwidth(q.Name, speedLimit)
+
+	active := 0
+	for _, d := range m.downloads {
+		if d.Queue != q.Name {
+			continue
+		}
+		m.applyProxy(d)
+		if d.Status == "downloading" || d.Status == "paused" {
+			active++
+		}
+	}
+	logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Queue %s: Speed limit set to %d KB/s for %d active downloads",
+		q.Name, speedLimit, active))
+
+	// Window or concurrency changes may let more downloads start, and the next
+	// schedule change may have moved
+	m.notify()
+}
+
 // Start begins the queue manager's operation
 func (m *Manager) Start() {
 	logger.LogDownloadEvent("SYSTEM", "Queue Manager started")
@@ -52,20 +129,84 @@ func (m *Manager) Start() {
 // Stop stops the queue manager
 func (m *Manager) Stop() {
 	logger.LogDownloadEvent("SYSTEM", "Queue Manager stopped")
-	m.ticker.Stop()
+	m.stopOnce.Do(func() { close(m.stop) })
+}
+
+// notify wakes the run loop to process queues after something changed: a download
+// finished, failed, was paused, cancelled or added, or the config was edited
+func (m *Manager) notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+		// A wake-up is already pending
+	}
 }
 
-// run is the main loop that processes downloads
+// run is the main loop that processes downloads. It runs whenever notify is called,
+// when the next queue window opens or closes or a bandwidth window changes, and when
+// a queue uses up its quota.
 func (m *Manager) run() {
-	// Process queues more frequently to better handle time windows
-	ticker := time.NewTicker(time.Minute)
-	defer ticker.Stop()
+	usage := time.NewTicker(usageInterval)
+	defer usage.Stop()
 
-	for range ticker.C {
+	for {
 		m.processQueues()
+
+		// With no schedule change ahead the timer channel stays nil and never fires
+		var timer *time.Timer
+		var fired <-chan time.Time
+		if next, ok := m.nextScheduleChange(time.Now()); ok {
+			timer = time.NewTimer(time.Until(next))
+			fired = timer.C
+		}
+
+		for waiting := true; waiting; {
+			select {
+			case <-m.wake:
+				waiting = false
+			case <-fired:
+				logger.LogDownloadEvent("SYSTEM", "Queue schedule changed")
+				waiting = false
+			case <-usage.C:
+				// Only a quota running out needs the queues processed
+				waiting = !m.trackUsage(time.Now())
+			case <-m.stop:
+				if timer != nil {
+					timer.Stop()
+				}
+				return
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
 	}
 }
 
+// nextScheduleChange returns the earliest time a queue's window opens or closes, its
+// bandwidth limit changes or a used up quota resets
+func (m *Manager) nextScheduleChange(now time.Time) (time.Time, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var next time.Time
+	consider := func(t time.Time, ok bool) {
+		if ok && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	for i := range m.config.Queues {
+		q := &m.config.Queues[i]
+		if !q.Enabled {
+			continue
+		}
+		consider(q.Schedule().NextChange(now))
+		consider(q.NextBandwidthChange(now))
+		consider(q.QuotaResetAt(now))
+	}
+	return next, !next.IsZero()
+}
+
 // PauseDownload pauses a specific download
 func (m *Manager) PauseDownload(url string) {
 	m.mutex.Lock()
@@ -74,12 +215,16 @@ func (m *Manager) PauseDownload(url string) {
 	if d, exists := m.downloads[url]; exists && d.Status == "downloading" {
 		logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Pausing download %s in queue %s", url, d.Queue))
 		d.Pause()
-		m.activeJobs[d.Queue]--
+		m.markInactive(url)
+		delete(m.pausedBySchedule, url)
 
 		// Save state
 		if err := config.SaveConfig(m.config); err != nil {
 			logger.LogDownloadError(url, d.Queue, fmt.Sprintf("Failed to save config when pausing: %v", err))
 		}
+
+		// The freed slot can go to a pending download
+		m.notify()
 	}
 }
 
@@ -96,9 +241,14 @@ func (m *Manager) ResumeDownload(url string) {
 			return
 		}
 
-		if !queueCfg.IsTimeAllowed() {
-			logger.LogDownloadPending(url, d.Queue, fmt.Sprintf("Cannot resume: outside allowed time window (%s-%s)",
-				queueCfg.StartTime, queueCfg.EndTime))
+		if !queueCfg.AllowedAt(time.Now()) {
+			logger.LogDownloadPending(url, d.Queue, fmt.Sprintf("Cannot resume: outside allowed time window (%s)",
+				queueCfg.Schedule()))
+			return
+		}
+
+		if reason := queueCfg.QuotaExceeded(time.Now()); reason != "" {
+			logger.LogDownloadPending(url, d.Queue, fmt.Sprintf("Cannot resume: %s", reason))
 			return
 		}
 
@@ -108,10 +258,15 @@ func (m *Manager) ResumeDownload(url string) {
 			return
 		}
 
+		if err := m.admit(d); err != nil {
+			logger.LogDownloadPending(url, d.Queue, fmt.Sprintf("Cannot resume: %v", err))
+			return
+		}
+
 		// Resume the download
 		logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Resuming download %s in queue %s", url, d.Queue))
-		d.Resume()
-		m.activeJobs[d.Queue]++
+		m.resume(d, queueCfg)
+		delete(m.pausedBySchedule, url)
 
 		// Save state
 		if err := config.SaveConfig(m.config); err != nil {
@@ -120,107 +275,680 @@ func (m *Manager) ResumeDownload(url string) {
 	}
 }
 
-// processQueues checks each queue and starts eligible downloads
+// PauseQueue pauses every running download in a queue and holds the queue so nothing
+// new starts until ResumeQueue. It returns how many downloads were paused.
+func (m *Manager) PauseQueue(name string) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	q := m.config.GetQueue(name)
+	if q == nil {
+		return 0, fmt.Errorf("queue %s not found", name)
+	}
+	q.Paused = true
+
+	paused := 0
+	for _, d := range m.queueDownloads(name) {
+		if d.GetStatus() == "downloading" {
+			d.Pause()
+			m.markInactive(d.URL)
+			delete(m.pausedBySchedule, d.URL)
+			paused++
+		}
+	}
+	logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Queue %s: Paused %d downloads", name, paused))
+
+	if err := config.SaveConfig(m.config); err != nil {
+		logger.LogDownloadError("", name, fmt.Sprintf("Failed to save config when pausing queue: %v", err))
+	}
+	return paused, nil
+}
+
+// ResumeQueue ends a pause or drain and resumes a queue's paused downloads in start order,
+// up to its concurrency limit. It returns how many were resumed.
+func (m *Manager) ResumeQueue(name string) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	q := m.config.GetQueue(name)
+	if q == nil {
+		return 0, fmt.Errorf("queue %s not found", name)
+	}
+	q.Paused, q.Draining = false, false
+	defer func() {
+		if err := config.SaveConfig(m.config); err != nil {
+			logger.LogDownloadError("", name, fmt.Sprintf("Failed to save config when resuming queue: %v", err))
+		}
+	}()
+	reason := q.QuotaExceeded(time.Now())
+	if !q.AllowedAt(time.Now()) {
+		reason = fmt.Sprintf("disabled or outside its time window (%s)", q.Schedule())
+	}
+	if reason != "" {
+		// Leave them for processQueues to resume once the queue may run
+		for _, d := range m.queueDownloads(name) {
+			if d.GetStatus() == "paused" {
+				m.pausedBySchedule[d.URL] = true
+			}
+		}
+		return 0, fmt.Errorf("queue %s: %s, its downloads will resume when it may run", name, reason)
+	}
+
+	resumed := 0
+	for _, d := range m.queueDownloads(name) {
+		if m.activeJobs[name] >= q.MaxConcurrent {
+			break
+		}
+		if d.GetStatus() != "paused" {
+			continue
+		}
+		if m.admit(d) != nil {
+			// Resume it once the global or per-host cap allows
+			m.pausedBySchedule[d.URL] = true
+			continue
+		}
+		m.resume(d, q)
+		delete(m.pausedBySchedule, d.URL)
+		resumed++
+	}
+	logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Queue %s: Resumed %d downloads", name, resumed))
+
+	// Free slots go to pending downloads
+	m.notify()
+	return resumed, nil
+}
+
+// DrainQueue lets a queue's active downloads finish but starts no new ones until
+// ResumeQueue is called
+func (m *Manager) DrainQueue(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	q := m.config.GetQueue(name)
+	if q == nil {
+		return fmt.Errorf("queue %s not found", name)
+	}
+	q.Draining = true
+	logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Queue %s: Draining, %d downloads still active", name, m.activeJobs[name]))
+
+	if err := config.SaveConfig(m.config); err != nil {
+		logger.LogDownloadError("", name, fmt.Sprintf("Failed to save config when draining queue: %v", err))
+	}
+	return nil
+}
+
+// SetQueueEnabled enables or disables a queue. A disabled queue starts no downloads;
+// the ones already running carry on.
+func (m *Manager) SetQueueEnabled(name string, enabled bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	q := m.config.GetQueue(name)
+	if q == nil {
+		return fmt.Errorf("queue %s not found", name)
+	}
+	q.Enabled = enabled
+	logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Queue %s: Enabled set to %t", name, enabled))
+
+	if err := config.SaveConfig(m.config); err != nil {
+		logger.LogDownloadError("", name, fmt.Sprintf("Failed to save config when toggling queue: %v", err))
+	}
+	m.notify()
+	return nil
+}
+
+// ResolveConflict applies the user's collision policy to a download whose file
+// already exists and queues it again
+func (m *Manager) ResolveConflict(url, policy string) error {
+	m.mutex.Lock()
+	d, exists := m.downloads[url]
+	if !exists || d.Status != "conflict" {
+		m.mutex.Unlock()
+		return errors.New("download is not waiting for a decision")
+	}
+	d.CollisionPolicy = policy
+	d.Status = "pending"
+	d.Error = ""
+	m.mutex.Unlock()
+
+	logger.LogDownloadPending(url, d.Queue, fmt.Sprintf("File collision resolved with policy %s", policy))
+	m.ProcessDownload(url)
+	return nil
+}
+
+// processQueues checks each queue and starts eligible downloads, sharing the global cap
+// between the queues that can run
 func (m *Manager) processQueues() {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
 	logger.LogDownloadEvent("SYSTEM", "Processing queues")
 
+	// Follow bandwidth schedules
+	m.applyBandwidthLimits()
+
+	// Hold back downloads whose prerequisites failed
+	m.updateBlocked()
+
+	var ready []*config.QueueConfig
 	for _, queueCfg := range m.config.Queues {
 		if !queueCfg.Enabled {
 			logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Queue %s: Disabled", queueCfg.Name))
 			continue
 		}
 
-		if !queueCfg.IsTimeAllowed() {
-			logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Queue %s: Outside allowed time window (%s-%s)",
-				queueCfg.Name, queueCfg.StartTime, queueCfg.EndTime))
+		if !queueCfg.AllowedAt(time.Now()) {
+			logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Queue %s: Outside allowed time window (%s)",
+				queueCfg.Name, queueCfg.Schedule()))
 
 			// Pause any active downloads in this queue that are outside the time window
-			for _, download := range m.downloads {
-				if download.Queue == queueCfg.Name && download.Status == "downloading" {
-					download.Pause()
-					m.activeJobs[queueCfg.Name]--
-					logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Paused download %s: Outside allowed time window", download.URL))
-				}
-			}
+			m.holdQueue(queueCfg.Name, "Outside allowed time window")
 			continue
 		}
 
-		activeCount := m.activeJobs[queueCfg.Name]
-		if activeCount >= queueCfg.MaxConcurrent {
+		if reason := queueCfg.QuotaExceeded(time.Now()); reason != "" {
+			logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Queue %s: Paused, %s", queueCfg.Name, reason))
+			m.holdQueue(queueCfg.Name, reason)
+			continue
+		}
+
+		if queueCfg.Paused {
+			logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Queue %s: Paused", queueCfg.Name))
+			continue
+		}
+
+		if queueCfg.Draining {
+			logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Queue %s: Draining, %d downloads still active",
+				queueCfg.Name, m.activeJobs[queueCfg.Name]))
+			continue
+		}
+
+		if m.activeJobs[queueCfg.Name] >= queueCfg.MaxConcurrent {
 			logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Queue %s: At maximum capacity (%d/%d downloads)",
-				queueCfg.Name, activeCount, queueCfg.MaxConcurrent))
+				queueCfg.Name, m.activeJobs[queueCfg.Name], queueCfg.MaxConcurrent))
+			continue
+		}
+
+		ready = append(ready, m.config.GetQueue(queueCfg.Name))
+	}
+
+	m.fillSlots(ready)
+}
+
+// holdQueue pauses a queue's running downloads for a reason the manager will see end,
+// marking them to resume when it does
+func (m *Manager) holdQueue(name, reason string) {
+	for _, download := range m.queueDownloads(name) {
+		if download.Status == "downloading" {
+			download.Pause()
+			m.markInactive(download.URL)
+			m.pausedBySchedule[download.URL] = true
+			logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Paused download %s: %s", download.URL, reason))
+		}
+	}
+}
+
+// trackUsage counts the bytes downloaded since the last call against each queue's
+// quotas. It reports whether a queue with running downloads has used up a quota.
+func (m *Manager) trackUsage(now time.Time) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	counted := false
+	for i := range m.config.Downloads {
+		d := &m.config.Downloads[i]
+		last, tracked := m.lastDownloaded[d.URL]
+		if !tracked {
 			continue
 		}
 
-		// Resume any paused downloads that were paused due to time restrictions
-		for _, download := range m.downloads {
-			if download.Queue == queueCfg.Name && download.Status == "paused" {
-				if activeCount < queueCfg.MaxConcurrent {
-					download.Resume()
-					m.activeJobs[queueCfg.Name]++
-					activeCount++
-					logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Resumed download %s: Within allowed time window", download.URL))
+		current := d.GetDownloaded()
+		if current < last {
+			// Started over from the beginning
+			last = 0
+		}
+		if delta := current - last; delta > 0 {
+			if q := m.config.GetQueue(d.Queue); q != nil {
+				q.AddUsage(delta, now)
+				counted = true
+			}
+		}
+		m.lastDownloaded[d.URL] = current
+
+		// Stop tracking once the download no longer runs; starting it again sets a new baseline
+		if status := d.GetStatus(); status != "downloading" && status != "paused" {
+			delete(m.lastDownloaded, d.URL)
+		}
+	}
+
+	exceeded := false
+	for i := range m.config.Queues {
+		q := &m.config.Queues[i]
+		if m.activeJobs[q.Name] > 0 && q.QuotaExceeded(now) != "" {
+			exceeded = true
+		}
+	}
+
+	// Keep the counts across restarts without rewriting the config every tick
+	if counted && (exceeded || now.Sub(m.lastUsageSave) >= usageSaveInterval) {
+		if err := config.SaveConfig(m.config); err != nil {
+			logger.LogDownloadError("", "", fmt.Sprintf("Failed to save config with quota usage: %v", err))
+		}
+		m.lastUsageSave = now
+	}
+	return exceeded
+}
+
+// fillSlots starts downloads from the ready queues one at a time in turn, so that when
+// the global cap is reached every queue has had its share. Each turn goes to the queue's
+// first download the manager paused, or failing that its first pending one.
+// Turns begin after the queue served last by the previous call.
+func (m *Manager) fillSlots(ready []*config.QueueConfig) {
+	if len(ready) == 0 {
+		return
+	}
+	first := 0
+	for i, q := range ready {
+		if q.Name == m.lastServed {
+			first = (i + 1) % len(ready)
+			break
+		}
+	}
+	ready = append(ready[first:], ready[:first]...)
+
+	started := make(map[string]int)
+	for {
+		progress := false
+		for _, q := range ready {
+			if m.activeJobs[q.Name] >= q.MaxConcurrent {
+				continue
+			}
+			if m.config.MaxConcurrentDownloads > 0 && m.totalActive() >= m.config.MaxConcurrentDownloads {
+				logger.LogDownloadEvent("QUEUE", fmt.Sprintf("At global maximum (%d downloads)", m.config.MaxConcurrentDownloads))
+				m.logStarted(ready, started)
+				return
+			}
+
+			if d := m.nextToStart(q.Name); d != nil {
+				if d.Status == "paused" {
+					delete(m.pausedBySchedule, d.URL)
+					m.resume(d, q)
+					logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Resumed download %s: Within allowed time window", d.URL))
+				} else {
+					m.startDownload(d, q)
+					m.downloads[d.URL] = d
 				}
+				started[q.Name]++
+				m.lastServed = q.Name
+				progress = true
 			}
 		}
+		if !progress {
+			break
+		}
+	}
+	m.logStarted(ready, started)
+}
 
-		// Find pending downloads for this queue
+// nextToStart returns the queue's next download whose prerequisites have completed and
+// which the per-host cap allows to start, or nil. A pending download whose last run is
+// still winding up waits for it, so two runs never write the same .part file.
+func (m *Manager) nextToStart(queue string) *downloader.Download {
+	downloads := m.queueDownloads(queue)
+	for _, d := range downloads {
+		if d.Status == "paused" && m.pausedBySchedule[d.URL] && m.admit(d) == nil {
+			return d
+		}
+	}
+	for _, d := range downloads {
+		if d.Status != "pending" || m.running[d.URL] {
+			continue
+		}
+		if ready, _ := m.dependencyState(d); ready && m.admit(d) == nil {
+			return d
+		}
+	}
+	return nil
+}
+
+// logStarted logs how many downloads each ready queue started
+func (m *Manager) logStarted(ready []*config.QueueConfig, started map[string]int) {
+	for _, q := range ready {
 		pendingCount := 0
-		startedCount := 0
-		for i := range m.config.Downloads {
-			download := &m.config.Downloads[i]
-			if download.Queue == queueCfg.Name && download.Status == "pending" {
+		for _, d := range m.queueDownloads(q.Name) {
+			if d.Status == "pending" {
 				pendingCount++
-				if activeCount < queueCfg.MaxConcurrent {
-					m.startDownload(download, &queueCfg)
-					m.downloads[download.URL] = download
-					activeCount++
-					startedCount++
-				}
 			}
 		}
+		logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Queue %s: Started %d downloads, %d still pending (%d/%d active)",
+			q.Name, started[q.Name], pendingCount, m.activeJobs[q.Name], q.MaxConcurrent))
+	}
+}
+
+// totalActive returns the number of running downloads across all queues
+func (m *Manager) totalActive() int {
+	total := 0
+	for _, count := range m.activeJobs {
+		total += count
+	}
+	return total
+}
+
+// admit checks the global and per-host caps for starting or resuming d, returning
+// why it has to wait if it can't
+func (m *Manager) admit(d *downloader.Download) error {
+	if limit := m.config.MaxConcurrentDownloads; limit > 0 && m.totalActive() >= limit {
+		return fmt.Errorf("at global maximum (%d downloads)", limit)
+	}
 
-		logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Queue %s: Started %d of %d pending downloads (%d/%d active)",
-			queueCfg.Name, startedCount, pendingCount, activeCount, queueCfg.MaxConcurrent))
+	limit := m.config.MaxConnectionsPerHost
+	if limit <= 0 {
+		return nil
+	}
+	host := downloadHost(d)
+	used := 0
+	for i := range m.config.Downloads {
+		other := &m.config.Downloads[i]
+		if other.Status == "downloading" && other.URL != d.URL && downloadHost(other) == host {
+			used += downloadConnections(other)
+		}
 	}
+	// A download wanting more connections than the cap may still run on its own
+	if used > 0 && used+downloadConnections(d) > limit {
+		return fmt.Errorf("%s has %d of %d connections in use", host, used, limit)
+	}
+	return nil
+}
+
+// downloadHost returns the host a download connects to
+func downloadHost(d *downloader.Download) string {
+	u, err := url.Parse(d.URL)
+	if err != nil {
+		return d.URL
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// downloadConnections returns how many connections a download opens at most
+func downloadConnections(d *downloader.Download) int {
+	if d.SegmentCount > 1 {
+		return d.SegmentCount
+	}
+	return 1
+}
+
+// queueDownloads returns a queue's downloads in the order they start: by priority,
+// then by position, then by the order they were added
+func (m *Manager) queueDownloads(queue string) []*downloader.Download {
+	var downloads []*downloader.Download
+	for i := range m.config.Downloads {
+		if m.config.Downloads[i].Queue == queue {
+			downloads = append(downloads, &m.config.Downloads[i])
+		}
+	}
+	sort.SliceStable(downloads, func(i, j int) bool {
+		return downloader.StartsBefore(downloads[i], downloads[j])
+	})
+	return downloads
+}
+
+// findDownload returns the download with the given URL from the config
+func (m *Manager) findDownload(url string) *downloader.Download {
+	for i := range m.config.Downloads {
+		if m.config.Downloads[i].URL == url {
+			return &m.config.Downloads[i]
+		}
+	}
+	return nil
+}
+
+// SetPriority changes a download's priority; higher priorities start first in their queue
+func (m *Manager) SetPriority(url string, priority int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	d := m.findDownload(url)
+	if d == nil {
+		return errors.New("download not found")
+	}
+	d.Priority = priority
+	logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Priority of %s in queue %s set to %d", url, d.Queue, priority))
+
+	if err := config.SaveConfig(m.config); err != nil {
+		logger.LogDownloadError(url, d.Queue, fmt.Sprintf("Failed to save config after changing priority: %v", err))
+	}
+	m.notify()
+	return nil
+}
+
+// SetBandwidth changes a download's own speed limit in KB/s, 0 for unlimited. A running
+// download picks it up on its next read.
+func (m *Manager) SetBandwidth(url string, kbps int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	d := m.findDownload(url)
+	if d == nil {
+		return errors.New("download not found")
+	}
+	d.SetBandwidth(kbps)
+	logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Speed limit of %s in queue %s set to %d KB/s", url, d.Queue, kbps))
+
+	if err := config.SaveConfig(m.config); err != nil {
+		logger.LogDownloadError(url, d.Queue, fmt.Sprintf("Failed to save config after changing speed limit: %v", err))
+	}
+	return nil
+}
+
+// MoveInQueue moves a download offset places in its queue's start order, -1 for one
+// place earlier. Moving past a download of another priority takes on its priority.
+func (m *Manager) MoveInQueue(url string, offset int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	d := m.findDownload(url)
+	if d == nil {
+		return errors.New("download not found")
+	}
+
+	downloads := m.queueDownloads(d.Queue)
+	from := -1
+	for i, other := range downloads {
+		if other == d {
+			from = i
+			break
+		}
+	}
+	to := from + offset
+	if to < 0 {
+		return fmt.Errorf("download is already first in queue %s", d.Queue)
+	}
+	if to >= len(downloads) {
+		return fmt.Errorf("download is already last in queue %s", d.Queue)
+	}
+
+	d.Priority = downloads[to].Priority
+	downloads = append(downloads[:from], downloads[from+1:]...)
+	downloads = append(downloads[:to], append([]*downloader.Download{d}, downloads[to:]...)...)
+	for i, other := range downloads {
+		other.Position = i + 1
+	}
+	logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Moved %s to place %d in queue %s", url, to+1, d.Queue))
+
+	if err := config.SaveConfig(m.config); err != nil {
+		logger.LogDownloadError(url, d.Queue, fmt.Sprintf("Failed to save config after reordering: %v", err))
+	}
+	m.notify()
+	return nil
+}
+
+// MoveDownload reassigns a pending, paused or failed download to another queue. Its
+// partial data moves to the new queue's directory, and it then follows that queue's
+// position, concurrency and bandwidth limits.
+func (m *Manager) MoveDownload(url, queue string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	d := m.findDownload(url)
+	if d == nil {
+		return errors.New("download not found")
+	}
+	target := m.config.GetQueue(queue)
+	if target == nil {
+		return fmt.Errorf("queue %s not found", queue)
+	}
+	if d.Queue == queue {
+		return nil
+	}
+
+	status := d.GetStatus()
+	switch status {
+	case "pending", "paused", "error", "verify-failed", "blocked":
+	default:
+		return fmt.Errorf("cannot move a %s download", status)
+	}
+
+	// Follow the new queue's directory, as if the download had been added there
+	dir := target.Path
+	if dir == "" {
+		dir = m.config.SavePath
+	}
+	if err := d.Relocate(filepath.Join(dir, filepath.Base(d.TargetPath))); err != nil {
+		logger.LogDownloadError(url, d.Queue, fmt.Sprintf("Failed to move download to queue %s: %v", queue, err))
+		return err
+	}
+
+	oldQueue := d.Queue
+	d.Position = m.nextPosition(queue)
+	d.Queue = queue
+	m.applyProxy(d)
+	logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Moved %s download %s from queue %s to queue %s", status, url, oldQueue, queue))
+
+	// A paused download stays paused; it counts against the new queue once resumed
+	if err := config.SaveConfig(m.config); err != nil {
+		logger.LogDownloadError(url, queue, fmt.Sprintf("Failed to save config after moving download: %v", err))
+	}
+	m.notify()
+	return nil
+}
+
+// NextPosition returns the position for a download added at the end of a queue.
+// Downloads that were never reordered have position 0 and keep the order they were added in.
+func (m *Manager) NextPosition(queue string) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.nextPosition(queue)
+}
+
+// nextPosition is NextPosition for callers holding the mutex
+func (m *Manager) nextPosition(queue string) int {
+	position, count := 0, 0
+	for i := range m.config.Downloads {
+		if d := &m.config.Downloads[i]; d.Queue == queue {
+			count++
+			if d.Position > position {
+				position = d.Position
+			}
+		}
+	}
+	if count > position {
+		position = count
+	}
+	return position + 1
+}
+
+// markActive counts a download against its queue's concurrency limit, once
+func (m *Manager) markActive(d *downloader.Download) {
+	if _, counted := m.active[d.URL]; counted {
+		return
+	}
+	m.active[d.URL] = d.Queue
+	m.activeJobs[d.Queue]++
+}
+
+// markInactive frees the slot a download holds, if it holds one, and returns the
+// queue it was counted against
+func (m *Manager) markInactive(url string) (string, bool) {
+	queue, counted := m.active[url]
+	if counted {
+		delete(m.active, url)
+		m.activeJobs[queue]--
+	}
+	return queue, counted
+}
+
+// resume carries on a paused download, which then holds a slot in its queue. A Start
+// waiting for it is woken; a download paused before the app last exited has none, so it
+// is started again and picks up from its .part file.
+func (m *Manager) resume(d *downloader.Download, q *config.QueueConfig) {
+	if d.Resume() {
+		m.markActive(d)
+		return
+	}
+	if !m.running[d.URL] {
+		m.startDownload(d, q)
+	}
+}
+
+// applyProxy points a download at the proxy of the queue it is in now. The proxy is
+// not saved with the download, so edits to the proxy settings reach it on its next connection.
+func (m *Manager) applyProxy(d *downloader.Download) {
+	d.SetProxy(m.config.ProxyFor(d.Queue))
 }
 
 // startDownload begins a new download
 func (m *Manager) startDownload(d *downloader.Download, q *config.QueueConfig) {
 	d.Status = "downloading"
-	m.activeJobs[q.Name]++
+	m.running[d.URL] = true
+	m.markActive(d)
+	m.applyProxy(d)
+	m.lastDownloaded[d.URL] = d.Downloaded
 
 	logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Starting download %s in queue %s", d.URL, q.Name))
 
 	go func() {
 		// Start the actual download
-		err := d.Start()
+		err := d.Start(context.Background())
 
 		m.mutex.Lock()
 		defer m.mutex.Unlock()
+		delete(m.running, d.URL)
+
+		// The download may have been moved to another queue while it was paused
+		queueName := d.Queue
 
 		// Update download status
-		if err != nil && d.Status != "cancelled" {
+		if d.Status == "conflict" {
+			logger.LogDownloadPending(d.URL, queueName, fmt.Sprintf("Waiting for a decision: %v", err))
+		} else if d.Status == "verify-failed" {
+			logger.LogDownloadError(d.URL, queueName, fmt.Sprintf("Download failed verification: %v", err))
+		} else if err != nil && d.Status != "cancelled" {
 			d.Status = "error"
 			d.Error = err.Error()
-			logger.LogDownloadError(d.URL, q.Name, fmt.Sprintf("Download failed: %v", err))
+			logger.LogDownloadError(d.URL, queueName, fmt.Sprintf("Download failed: %v", err))
 		} else if d.Status != "cancelled" {
 			d.Status = "completed"
-			logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Download %s completed in queue %s", d.URL, q.Name))
+			logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Download %s completed in queue %s", d.URL, queueName))
 		}
 
-		// Decrease active job count
-		m.activeJobs[q.Name]--
-		logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Queue %s: Active downloads decreased to %d/%d",
-			q.Name, m.activeJobs[q.Name], q.MaxConcurrent))
+		// Free the slot, unless a pause already did: a download cancelled while paused
+		// no longer holds one
+		if queueName, counted := m.markInactive(d.URL); counted {
+			maxConcurrent := 0
+			if queueCfg := m.config.GetQueue(queueName); queueCfg != nil {
+				maxConcurrent = queueCfg.MaxConcurrent
+			}
+			logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Queue %s: Active downloads decreased to %d/%d",
+				queueName, m.activeJobs[queueName], maxConcurrent))
+		}
 
 		// Save the updated state
 		if err := config.SaveConfig(m.config); err != nil {
-			logger.LogDownloadError(d.URL, q.Name, fmt.Sprintf("Failed to save config after download: %v", err))
+			logger.LogDownloadError(d.URL, queueName, fmt.Sprintf("Failed to save config after download: %v", err))
 		}
+
+		// Start the next pending download in the freed slot
+		m.notify()
 	}()
 }
 
@@ -235,10 +963,9 @@ func (m *Manager) RemoveDownload(url string) {
 		if d.URL == url {
 			queueName = d.Queue
 			// Update active jobs count if needed
-			if d.Status == "downloading" {
-				m.activeJobs[d.Queue]--
+			if counted, ok := m.markInactive(url); ok {
 				logger.LogDownloadEvent("QUEUE", fmt.Sprintf("Queue %s: Active downloads decreased to %d",
-					d.Queue, m.activeJobs[d.Queue]))
+					counted, m.activeJobs[counted]))
 			}
 			break
 		}
@@ -246,6 +973,7 @@ func (m *Manager) RemoveDownload(url string) {
 
 	// Remove from active downloads
 	delete(m.downloads, url)
+	delete(m.lastDownloaded, url)
 
 	// Remove from config downloads
 	for i, d := range m.config.Downloads {
@@ -255,6 +983,8 @@ func (m *Manager) RemoveDownload(url string) {
 			break
 		}
 	}
+
+	m.notify()
 }
 
 // ProcessDownload processes a specific download (used for retrying downloads)
@@ -263,6 +993,12 @@ func (m *Manager) ProcessDownload(url string) {
 	defer m.mutex.Unlock()
 
 	if d, exists := m.downloads[url]; exists && d.Status == "pending" {
+		// A download retried or resolved before its last run has wound up starts once it has
+		if m.running[url] {
+			logger.LogDownloadPending(url, d.Queue, "Cannot process: previous run still finishing")
+			return
+		}
+
 		// Find the queue configuration
 		var queueCfg *config.QueueConfig
 		for _, q := range m.config.Queues {
@@ -277,9 +1013,14 @@ func (m *Manager) ProcessDownload(url string) {
 			return
 		}
 
-		if !queueCfg.IsTimeAllowed() {
-			logger.LogDownloadPending(url, d.Queue, fmt.Sprintf("Cannot process: outside allowed time window (%s-%s)",
-				queueCfg.StartTime, queueCfg.EndTime))
+		if !queueCfg.AllowedAt(time.Now()) {
+			logger.LogDownloadPending(url, d.Queue, fmt.Sprintf("Cannot process: outside allowed time window (%s)",
+				queueCfg.Schedule()))
+			return
+		}
+
+		if reason := queueCfg.QuotaExceeded(time.Now()); reason != "" {
+			logger.LogDownloadPending(url, d.Queue, fmt.Sprintf("Cannot process: %s", reason))
 			return
 		}
 
@@ -291,6 +1032,16 @@ func (m *Manager) ProcessDownload(url string) {
 			return
 		}
 
+		if ready, _ := m.dependencyState(d); !ready {
+			logger.LogDownloadPending(url, d.Queue, "Cannot process: waiting for prerequisites to complete")
+			return
+		}
+
+		if err := m.admit(d); err != nil {
+			logger.LogDownloadPending(url, d.Queue, fmt.Sprintf("Cannot process: %v", err))
+			return
+		}
+
 		// Process the download
 		m.startDownload(d, queueCfg)
 
@@ -298,13 +1049,9 @@ func (m *Manager) ProcessDownload(url string) {
 	}
 }
 
-// ProcessAllQueues immediately processes all queues (used when a new download is added)
+// ProcessAllQueues asks the run loop to process all queues now (used when a new download is added)
 func (m *Manager) ProcessAllQueues() {
-	go func() {
-		// Small delay to allow the UI to update
-		//time.Sleep(100 * time.Millisecond)
-		m.processQueues()
-	}()
+	m.notify()
 }
 
 // AddURL adds a URL to the queue with error handling
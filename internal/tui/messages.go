@@ -2,8 +2,9 @@ package tui
 
 // Custom messages for our application
 type StartDownloadMsg struct {
-	URL   string
-	Queue string
+	URL     string
+	Queue   string
+	Options DownloadOptions
 }
 
 type TickMsg struct{}
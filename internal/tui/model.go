@@ -3,9 +3,10 @@ package tui
 import (
 	"fmt"
 	// "net/http"
-	// "strings"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -36,6 +37,7 @@ type Model struct {
 	// Add Download state
 	QueueSelectionMode bool   // Whether we're in queue selection mode
 	URLInputMode       bool   // Whether we're in URL input mode
+	URLInputField      int    // Active field in URL input mode, see urlInputFields
 	AddDownloadMessage string // Message shown after an add download operation
 	AddDownloadSuccess bool   // Whether the last add was successful (for coloring)
 
@@ -43,9 +45,18 @@ type Model struct {
 	DownloadListMessage string // Message shown in the download list tab
 	DownloadListSuccess bool   // Whether the last download list operation was successful (for coloring)
 
+	// Queue List state
+	QueueListMessage string // Message shown in the queue list tab
+	QueueListSuccess bool   // Whether the last queue list operation was successful (for coloring)
+
 	// Input fields
-	InputURL   string
-	InputQueue string
+	InputURL        string
+	InputQueue      string
+	InputChecksum   string
+	InputUserAgent  string
+	InputHeaders    string
+	InputCookieFile string
+	InputDependsOn  string // Downloads to wait for, as list numbers or URLs separated by commas
 
 	// Input fields for queue form
 	InputQueueName       string
@@ -54,8 +65,13 @@ type Model struct {
 	InputQueueSpeedLimit string
 	InputQueueStartTime  string
 	InputQueueEndTime    string
-	QueueFormMode        bool // Whether we're in queue form mode
-	QueueFormField       int  // Current field in queue form
+	InputQueueSegments   string
+	InputQueueSchedule   string // Bandwidth schedule, "09:00-18:00=200, ..."
+	InputQueueWindows    string // Allowed windows, "weekdays 23:00-06:00; weekends 00:00-23:59"
+	InputQueueDaily      string // Daily quota, "500M", "2G", 0 for none
+	InputQueueMonthly    string // Monthly quota
+	QueueFormMode        bool   // Whether we're in queue form mode
+	QueueFormField       int    // Current field in queue form
 
 	// Add input fields for scheduled start date and time
 	InputScheduledStartDate string // New field for scheduled start date
@@ -78,6 +94,15 @@ type Model struct {
 	CompletionTime time.Time
 }
 
+// DownloadOptions holds the optional per-download settings of the Add Download tab
+type DownloadOptions struct {
+	Checksum   string // Expected digest such as "sha256:<hex>", or "auto" to look it up
+	UserAgent  string
+	Headers    string   // "Name: value | Other: value"
+	CookieFile string   // Path to a cookies.txt file
+	DependsOn  []string // URLs of downloads that must complete first
+}
+
 // NewModel creates and initializes a new model
 func NewModel() Model {
 	// Load config
@@ -133,6 +158,11 @@ func (m *Model) HandleInput(msg tea.KeyMsg) (Model, tea.Cmd) {
 			m.InputQueueSpeedLimit = ""
 			m.InputQueueStartTime = ""
 			m.InputQueueEndTime = ""
+			m.InputQueueSegments = ""
+			m.InputQueueSchedule = ""
+			m.InputQueueWindows = ""
+			m.InputQueueDaily = ""
+			m.InputQueueMonthly = ""
 			m.QueueFormField = 0
 		} else {
 			m.InputMode = false
@@ -167,6 +197,26 @@ func (m *Model) HandleInput(msg tea.KeyMsg) (Model, tea.Cmd) {
 				if len(m.InputQueueEndTime) > 0 {
 					m.InputQueueEndTime = m.InputQueueEndTime[:len(m.InputQueueEndTime)-1]
 				}
+			case 6:
+				if len(m.InputQueueSegments) > 0 {
+					m.InputQueueSegments = m.InputQueueSegments[:len(m.InputQueueSegments)-1]
+				}
+			case 7:
+				if len(m.InputQueueSchedule) > 0 {
+					m.InputQueueSchedule = m.InputQueueSchedule[:len(m.InputQueueSchedule)-1]
+				}
+			case 8:
+				if len(m.InputQueueWindows) > 0 {
+					m.InputQueueWindows = m.InputQueueWindows[:len(m.InputQueueWindows)-1]
+				}
+			case 9:
+				if len(m.InputQueueDaily) > 0 {
+					m.InputQueueDaily = m.InputQueueDaily[:len(m.InputQueueDaily)-1]
+				}
+			case 10:
+				if len(m.InputQueueMonthly) > 0 {
+					m.InputQueueMonthly = m.InputQueueMonthly[:len(m.InputQueueMonthly)-1]
+				}
 			}
 		} else if m.InputMode {
 			if len(m.InputURL) > 0 {
@@ -191,6 +241,16 @@ func (m *Model) HandleInput(msg tea.KeyMsg) (Model, tea.Cmd) {
 				m.InputQueueStartTime += string(msg.Runes)
 			case 5:
 				m.InputQueueEndTime += string(msg.Runes)
+			case 6:
+				m.InputQueueSegments += string(msg.Runes)
+			case 7:
+				m.InputQueueSchedule += string(msg.Runes)
+			case 8:
+				m.InputQueueWindows += string(msg.Runes)
+			case 9:
+				m.InputQueueDaily += string(msg.Runes)
+			case 10:
+				m.InputQueueMonthly += string(msg.Runes)
 			}
 		} else if m.InputMode {
 			m.InputURL += string(msg.Runes)
@@ -201,6 +261,53 @@ func (m *Model) HandleInput(msg tea.KeyMsg) (Model, tea.Cmd) {
 	return *m, nil
 }
 
+// urlInputFields returns the Add Download input fields in Tab order
+func (m *Model) urlInputFields() []*string {
+	return []*string{&m.InputURL, &m.InputChecksum, &m.InputUserAgent, &m.InputHeaders, &m.InputCookieFile, &m.InputDependsOn}
+}
+
+// resolveDependencies turns "2, 5, https://..." into download URLs, taking numbers as
+// rows of the Download List
+func (m *Model) resolveDependencies(input string) ([]string, error) {
+	var urls []string
+	order := m.downloadOrder()
+	for _, entry := range strings.Split(input, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if row, err := strconv.Atoi(entry); err == nil {
+			if row < 1 || row > len(order) {
+				return nil, fmt.Errorf("no download #%d in the list", row)
+			}
+			urls = append(urls, m.Downloads[order[row-1]].URL)
+			continue
+		}
+
+		found := false
+		for i := range m.Downloads {
+			if m.Downloads[i].URL == entry {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%s is not in the download list", entry)
+		}
+		urls = append(urls, entry)
+	}
+	return urls, nil
+}
+
+// clearURLInput resets all Add Download input fields
+func (m *Model) clearURLInput() {
+	for _, field := range m.urlInputFields() {
+		*field = ""
+	}
+	m.URLInputField = 0
+}
+
 // UpdateSize updates the model's terminal size
 func (m *Model) UpdateSize(width, height int) {
 	m.Width = width
@@ -208,22 +315,53 @@ func (m *Model) UpdateSize(width, height int) {
 }
 
 // AddDownload adds a new download to the model
-func (m *Model) AddDownload(url, queue string) {
+func (m *Model) AddDownload(url, queue string, opts DownloadOptions) {
 	if queue == "" {
 		queue = m.Config.DefaultQueue
 	}
 
-	// Get the queue configuration to set bandwidth limit
+	// Get the queue configuration to set the connection count. The queue's speed limit
+	// is shared by all of its downloads, so it is not copied onto each one.
 	var maxBandwidth int64 = 0
+	segmentCount := 0
+	fetchChecksum := opts.Checksum == "auto"
+	collisionPolicy := ""
+	var retryPolicy downloader.RetryPolicy
+	var stallPolicy downloader.StallPolicy
+	headers := make(map[string]string)
+	userAgent, cookieFile := opts.UserAgent, opts.CookieFile
 	for _, q := range m.Config.Queues {
 		if q.Name == queue {
-			maxBandwidth = q.SpeedLimit
+			segmentCount = q.SegmentCount
+			fetchChecksum = fetchChecksum || q.FetchChecksums
+			collisionPolicy = q.CollisionPolicy
+			retryPolicy = q.RetryPolicy
+			stallPolicy = q.StallPolicy
+
+			// Queue request defaults, overridden by what was entered for this download
+			for name, value := range q.Headers {
+				headers[name] = value
+			}
+			if userAgent == "" {
+				userAgent = q.UserAgent
+			}
+			if cookieFile == "" {
+				cookieFile = q.CookieFile
+			}
 			break
 		}
 	}
+	if opts.Headers != "" {
+		if parsed, err := downloader.ParseHeaders(opts.Headers); err == nil {
+			for name, value := range parsed {
+				headers[name] = value
+			}
+		}
+	}
 
-	// Get a proper target path from the URL
-	filename := filepath.Base(url)
+	// Provisional file name from the URL; the downloader replaces it with the
+	// server's Content-Disposition or redirect target once it connects
+	filename := downloader.FilenameFromURL(url)
 	queuePath := m.Config.SavePath // Default to the global SavePath
 
 	// Find the queue configuration
@@ -251,6 +389,24 @@ func (m *Model) AddDownload(url, queue string) {
 		scheduledStartTime, _ = time.Parse("2006-01-02 15:04", m.InputScheduledStartDate+" "+m.InputScheduledStartTime)
 	}
 	download := downloader.New(url, targetPath, queue, maxBandwidth, scheduledStartTime)
+	download.SegmentCount = segmentCount
+	download.NameFromServer = true
+	download.CollisionPolicy = collisionPolicy
+	download.RetryPolicy = retryPolicy
+	download.StallPolicy = stallPolicy
+	download.FetchChecksum = fetchChecksum
+	download.UserAgent = userAgent
+	download.CookieFile = cookieFile
+	if len(headers) > 0 {
+		download.Headers = headers
+	}
+	if opts.Checksum != "" && opts.Checksum != "auto" {
+		if err := download.SetChecksum(opts.Checksum); err != nil {
+			m.ErrorMessage = "Ignoring checksum: " + err.Error()
+		}
+	}
+	download.DependsOn = opts.DependsOn
+	download.Position = m.QueueManager.NextPosition(queue)
 	m.Downloads = append(m.Downloads, *download)
 
 	// Add to queue manager's downloads map for tracking
@@ -271,10 +427,266 @@ func (m *Model) AddDownload(url, queue string) {
 	}
 }
 
+// downloadOrder returns indexes into Downloads in the order they are listed. Each
+// queue's downloads keep the rows they were added in, arranged in start order.
+func (m *Model) downloadOrder() []int {
+	rows := make(map[string][]int)
+	var queues []string
+	for i := range m.Downloads {
+		q := m.Downloads[i].Queue
+		if _, seen := rows[q]; !seen {
+			queues = append(queues, q)
+		}
+		rows[q] = append(rows[q], i)
+	}
+
+	order := make([]int, len(m.Downloads))
+	for _, q := range queues {
+		sorted := append([]int(nil), rows[q]...)
+		sort.SliceStable(sorted, func(a, b int) bool {
+			return downloader.StartsBefore(&m.Downloads[sorted[a]], &m.Downloads[sorted[b]])
+		})
+		for k, row := range rows[q] {
+			order[row] = sorted[k]
+		}
+	}
+	return order
+}
+
+// selectedIndex returns the index into Downloads of the selected row, or -1
+func (m *Model) selectedIndex() int {
+	if m.Selected < 0 || m.Selected >= len(m.Downloads) {
+		return -1
+	}
+	return m.downloadOrder()[m.Selected]
+}
+
+// selectURL selects the row of the download with the given URL, which keeps a
+// download selected after it moves in the list
+func (m *Model) selectURL(url string) {
+	for row, index := range m.downloadOrder() {
+		if m.Downloads[index].URL == url {
+			m.Selected = row
+			return
+		}
+	}
+}
+
+// MoveSelected moves the selected download one place earlier (-1) or later (+1) in its queue
+func (m *Model) MoveSelected(offset int) {
+	i := m.selectedIndex()
+	if i < 0 {
+		return
+	}
+	url := m.Downloads[i].URL
+	if err := m.QueueManager.MoveInQueue(url, offset); err != nil {
+		m.DownloadListMessage = fmt.Sprintf("Error: %s", err.Error())
+		m.DownloadListSuccess = false
+		return
+	}
+
+	m.selectURL(url)
+	m.DownloadListMessage = ""
+}
+
+// MoveSelectedToNextQueue moves the selected download to the next queue in the Queue List
+func (m *Model) MoveSelectedToNextQueue() {
+	i := m.selectedIndex()
+	if i < 0 || len(m.Config.Queues) < 2 {
+		return
+	}
+	url, current := m.Downloads[i].URL, m.Downloads[i].Queue
+
+	next := m.Config.Queues[0].Name
+	for k, q := range m.Config.Queues {
+		if q.Name == current {
+			next = m.Config.Queues[(k+1)%len(m.Config.Queues)].Name
+			break
+		}
+	}
+
+	if err := m.QueueManager.MoveDownload(url, next); err != nil {
+		m.DownloadListMessage = fmt.Sprintf("Error: %s", err.Error())
+		m.DownloadListSuccess = false
+		return
+	}
+	m.selectURL(url)
+	m.DownloadListMessage = fmt.Sprintf("Moved to queue '%s'", next)
+	m.DownloadListSuccess = true
+}
+
+// ToggleWaitForAbove makes the selected download wait for the one listed above it, or
+// stops it waiting if it already does
+func (m *Model) ToggleWaitForAbove() {
+	order := m.downloadOrder()
+	if m.Selected < 1 || m.Selected >= len(order) {
+		return
+	}
+	d, above := &m.Downloads[order[m.Selected]], m.Downloads[order[m.Selected-1]].URL
+
+	var dependsOn []string
+	waiting := false
+	for _, url := range d.DependsOn {
+		if url == above {
+			waiting = true
+		} else {
+			dependsOn = append(dependsOn, url)
+		}
+	}
+	if !waiting {
+		dependsOn = append(dependsOn, above)
+	}
+
+	url := d.URL
+	if err := m.QueueManager.SetDependencies(url, dependsOn); err != nil {
+		m.DownloadListMessage = fmt.Sprintf("Error: %s", err.Error())
+		m.DownloadListSuccess = false
+		return
+	}
+	m.selectURL(url)
+	if waiting {
+		m.DownloadListMessage = fmt.Sprintf("Download #%d no longer waits for #%d", m.Selected+1, m.Selected)
+	} else {
+		m.DownloadListMessage = fmt.Sprintf("Download #%d waits for #%d", m.Selected+1, m.Selected)
+	}
+	m.DownloadListSuccess = true
+}
+
+// ChangePriority raises or lowers the selected download's priority
+func (m *Model) ChangePriority(delta int) {
+	i := m.selectedIndex()
+	if i < 0 {
+		return
+	}
+	url := m.Downloads[i].URL
+	priority := m.Downloads[i].Priority + delta
+	if err := m.QueueManager.SetPriority(url, priority); err != nil {
+		m.DownloadListMessage = fmt.Sprintf("Error: %s", err.Error())
+		m.DownloadListSuccess = false
+		return
+	}
+
+	m.selectURL(url)
+	m.DownloadListMessage = fmt.Sprintf("Priority set to %d", priority)
+	m.DownloadListSuccess = true
+}
+
+// bandwidthSteps are the speed limits, in KB/s, a download's own limit steps through;
+// above the last it is unlimited
+var bandwidthSteps = []int64{64, 128, 256, 512, 1024, 2048, 4096, 8192}
+
+// ChangeBandwidth lowers (-1) or raises (1) the selected download's own speed limit by
+// one step. It applies at once, even while the download runs.
+func (m *Model) ChangeBandwidth(step int) {
+	i := m.selectedIndex()
+	if i < 0 {
+		return
+	}
+	url := m.Downloads[i].URL
+	kbps := nextBandwidth(m.Downloads[i].MaxBandwidth, step)
+	if err := m.QueueManager.SetBandwidth(url, kbps); err != nil {
+		m.DownloadListMessage = fmt.Sprintf("Error: %s", err.Error())
+		m.DownloadListSuccess = false
+		return
+	}
+
+	if kbps == 0 {
+		m.DownloadListMessage = "Speed limit removed"
+	} else {
+		m.DownloadListMessage = fmt.Sprintf("Speed limit set to %d KB/s", kbps)
+	}
+	m.DownloadListSuccess = true
+}
+
+// nextBandwidth returns the step below (-1) or above (1) a limit, where 0 is unlimited
+func nextBandwidth(current int64, step int) int64 {
+	if step < 0 {
+		for i := len(bandwidthSteps) - 1; i >= 0; i-- {
+			if current <= 0 || bandwidthSteps[i] < current {
+				return bandwidthSteps[i]
+			}
+		}
+		return bandwidthSteps[0]
+	}
+	if current <= 0 {
+		return 0
+	}
+	for _, kbps := range bandwidthSteps {
+		if kbps > current {
+			return kbps
+		}
+	}
+	return 0
+}
+
+// selectedQueue returns the name of the queue selected in the Queue List, or "" if none
+func (m *Model) selectedQueue() string {
+	if m.QueueSelected < 0 || m.QueueSelected >= len(m.Config.Queues) {
+		return ""
+	}
+	return m.Config.Queues[m.QueueSelected].Name
+}
+
+// queueListResult shows the outcome of a queue operation in the Queue List
+func (m *Model) queueListResult(message string, err error) {
+	if err != nil {
+		m.QueueListMessage = fmt.Sprintf("Error: %s", err.Error())
+		m.QueueListSuccess = false
+		return
+	}
+	m.QueueListMessage = message
+	m.QueueListSuccess = true
+}
+
+// PauseSelectedQueue pauses every download in the selected queue
+func (m *Model) PauseSelectedQueue() {
+	name := m.selectedQueue()
+	if name == "" {
+		return
+	}
+	paused, err := m.QueueManager.PauseQueue(name)
+	m.queueListResult(fmt.Sprintf("Queue '%s' paused (%d downloads)", name, paused), err)
+}
+
+// ResumeSelectedQueue resumes the selected queue after a pause or drain
+func (m *Model) ResumeSelectedQueue() {
+	name := m.selectedQueue()
+	if name == "" {
+		return
+	}
+	resumed, err := m.QueueManager.ResumeQueue(name)
+	m.queueListResult(fmt.Sprintf("Queue '%s' resumed (%d downloads)", name, resumed), err)
+}
+
+// DrainSelectedQueue lets the selected queue finish its active downloads without starting new ones
+func (m *Model) DrainSelectedQueue() {
+	name := m.selectedQueue()
+	if name == "" {
+		return
+	}
+	err := m.QueueManager.DrainQueue(name)
+	m.queueListResult(fmt.Sprintf("Queue '%s' draining, no new downloads will start", name), err)
+}
+
+// ToggleSelectedQueue enables or disables the selected queue
+func (m *Model) ToggleSelectedQueue() {
+	name := m.selectedQueue()
+	if name == "" {
+		return
+	}
+	enabled := !m.Config.Queues[m.QueueSelected].Enabled
+	state := "disabled"
+	if enabled {
+		state = "enabled"
+	}
+	err := m.QueueManager.SetQueueEnabled(name, enabled)
+	m.queueListResult(fmt.Sprintf("Queue '%s' %s", name, state), err)
+}
+
 // PauseDownload pauses the selected download
 func (m *Model) PauseDownload() {
-	if m.Selected >= 0 && m.Selected < len(m.Downloads) {
-		download := &m.Downloads[m.Selected]
+	if i := m.selectedIndex(); i >= 0 {
+		download := &m.Downloads[i]
 		if download.Status == "downloading" {
 			// Set completion time to zero if paused
 			download.CompletionTime = time.Time{}
@@ -285,8 +697,8 @@ func (m *Model) PauseDownload() {
 
 // ResumeDownload resumes the selected download
 func (m *Model) ResumeDownload() {
-	if m.Selected >= 0 && m.Selected < len(m.Downloads) {
-		download := &m.Downloads[m.Selected]
+	if i := m.selectedIndex(); i >= 0 {
+		download := &m.Downloads[i]
 		if download.Status == "paused" {
 			// Reset start time when resuming
 			download.StartTime = time.Now()
@@ -297,8 +709,8 @@ func (m *Model) ResumeDownload() {
 
 // CancelDownload removes the selected download from the queue and downloads list
 func (m *Model) CancelDownload() {
-	if m.Selected >= 0 && m.Selected < len(m.Downloads) {
-		download := m.Downloads[m.Selected]
+	if i := m.selectedIndex(); i >= 0 {
+		download := m.Downloads[i]
 
 		// Set completion time if download is active
 		if download.Status == "downloading" || download.Status == "paused" {
@@ -312,7 +724,7 @@ func (m *Model) CancelDownload() {
 			m.QueueManager.RemoveDownload(download.URL)
 
 			// Remove from downloads list
-			m.Downloads = append(m.Downloads[:m.Selected], m.Downloads[m.Selected+1:]...)
+			m.Downloads = append(m.Downloads[:i], m.Downloads[i+1:]...)
 
 			// Adjust selection if needed
 			if m.Selected >= len(m.Downloads) {
@@ -406,69 +818,116 @@ func (m *Model) SaveQueueForm() error {
 		}
 	}
 
-	// Create the queue config
-	queue := config.QueueConfig{
-		Name:          m.InputQueueName,
-		Path:          m.InputQueuePath,
-		MaxConcurrent: maxConcurrent,
-		SpeedLimit:    speedLimit,
-		StartTime:     startTime,
-		EndTime:       endTime,
-		Enabled:       true,
+	segmentCount := 1 // Default - single connection
+	if m.InputQueueSegments != "" {
+		if val, err := strconv.Atoi(m.InputQueueSegments); err == nil && val > 0 {
+			segmentCount = val
+		}
 	}
 
-	// Check if we're editing an existing queue or creating a new one
-	found := false
-	for i, q := range m.Config.Queues {
-		if q.Name == m.InputQueueName {
-			// Update existing queue
-			m.Config.Queues[i] = queue
-			found = true
-			break
-		}
+	schedule, err := config.ParseBandwidthSchedule(m.InputQueueSchedule)
+	if err != nil {
+		return err
+	}
+
+	windows, err := config.ParseScheduleWindows(m.InputQueueWindows)
+	if err != nil {
+		return err
 	}
 
-	if !found {
+	dailyQuota, err := config.ParseByteSize(m.InputQueueDaily)
+	if err != nil {
+		return fmt.Errorf("daily quota: %w", err)
+	}
+	monthlyQuota, err := config.ParseByteSize(m.InputQueueMonthly)
+	if err != nil {
+		return fmt.Errorf("monthly quota: %w", err)
+	}
+
+	// Start from the existing queue so settings not shown in the form survive an edit
+	queue := config.QueueConfig{Enabled: true}
+	existing := m.Config.GetQueue(m.InputQueueName)
+	if existing != nil {
+		queue = *existing
+	}
+	queue.Name = m.InputQueueName
+	queue.Path = m.InputQueuePath
+	queue.MaxConcurrent = maxConcurrent
+	queue.SpeedLimit = speedLimit
+	queue.StartTime = startTime
+	queue.EndTime = endTime
+	queue.SegmentCount = segmentCount
+	queue.BandwidthSchedule = schedule
+	queue.Windows = windows
+	queue.DailyQuota = dailyQuota
+	queue.MonthlyQuota = monthlyQuota
+
+	if existing != nil {
+		// Update existing queue
+		*existing = queue
+	} else {
 		// Add new queue
 		m.Config.Queues = append(m.Config.Queues, queue)
 	}
 
+	// Apply the new limits to downloads already running in the queue
+	if m.QueueManager != nil {
+		m.QueueManager.ApplyQueueSettings(queue.Name)
+	}
+
 	// Save config
 	return config.SaveConfig(m.Config)
 }
 
+// ResolveConflict applies a collision policy to the selected download when its file already exists
+func (m *Model) ResolveConflict(policy string) {
+	if i := m.selectedIndex(); i >= 0 {
+		download := &m.Downloads[i]
+		if download.Status != "conflict" {
+			return
+		}
+
+		if err := m.QueueManager.ResolveConflict(download.URL, policy); err != nil {
+			m.DownloadListMessage = fmt.Sprintf("Error: %s", err.Error())
+			m.DownloadListSuccess = false
+			return
+		}
+		m.DownloadListMessage = fmt.Sprintf("Restarting download #%d with policy '%s'", m.Selected+1, policy)
+		m.DownloadListSuccess = true
+
+		if m.Config != nil {
+			if err := config.SaveConfig(m.Config); err != nil {
+				m.ErrorMessage = "Failed to save config: " + err.Error()
+			}
+		}
+	}
+}
+
 // RetryDownload retries the selected download if it's in error state
 func (m *Model) RetryDownload() {
-	if m.Selected >= 0 && m.Selected < len(m.Downloads) {
-		download := &m.Downloads[m.Selected]
-
-		// Check if download is in error state
-		if download.Status == "error" {
-			// Check if retry count is less than max retries (3)
-			if download.GetRetryCount() < 3 {
-				// Retry the download
-				err := download.Retry()
-				if err != nil {
-					m.DownloadListMessage = fmt.Sprintf("Error: %s", err.Error())
-					m.DownloadListSuccess = false
-				} else {
-					m.DownloadListMessage = fmt.Sprintf("Trying again to download file #%d", m.Selected+1)
-					m.DownloadListSuccess = true
-
-					// Queue the download for processing
-					m.QueueManager.ProcessDownload(download.URL)
-
-					// Update config
-					if m.Config != nil {
-						if err := config.SaveConfig(m.Config); err != nil {
-							m.ErrorMessage = "Failed to save config: " + err.Error()
-						}
+	if i := m.selectedIndex(); i >= 0 {
+		download := &m.Downloads[i]
+
+		// Check if download is in error state. Each retry gets a fresh round of
+		// attempts under the queue's retry policy.
+		if download.Status == "error" || download.Status == "verify-failed" {
+			err := download.Retry()
+			if err != nil {
+				m.DownloadListMessage = fmt.Sprintf("Error: %s", err.Error())
+				m.DownloadListSuccess = false
+			} else {
+				m.DownloadListMessage = fmt.Sprintf("Trying again to download file #%d", m.Selected+1)
+				m.DownloadListSuccess = true
+
+				// Queue the download for processing
+				m.QueueManager.ProcessDownload(download.URL)
+
+				// Update config
+				if m.Config != nil {
+					if err := config.SaveConfig(m.Config); err != nil {
+						m.ErrorMessage = "Failed to save config: " + err.Error()
 					}
 				}
-			} else {
-				// Max retries reached
-				m.DownloadListMessage = "Error: Maximum retry attempts (3) reached for this download"
-				m.DownloadListSuccess = false
 			}
 		} else {
 			// Not in error state
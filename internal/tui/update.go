@@ -3,11 +3,13 @@ package tui
 import (
 	"fmt"
 	"net/url"
+	"os"
 	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/mahdiXak47/Download-Manager/internal/config"
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
 )
 
 // Update handles all state updates
@@ -43,7 +45,16 @@ func handleKeyPress(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		case tea.KeyEsc:
 			// Cancel URL input and go back
 			m.URLInputMode = false
-			m.InputURL = ""
+			m.clearURLInput()
+			return m, nil
+		case tea.KeyTab:
+			// Move to the next input field
+			m.URLInputField = (m.URLInputField + 1) % len(m.urlInputFields())
+			return m, nil
+		case tea.KeyShiftTab:
+			// Move to the previous input field
+			fieldCount := len(m.urlInputFields())
+			m.URLInputField = (m.URLInputField + fieldCount - 1) % fieldCount
 			return m, nil
 		case tea.KeyEnter:
 			// Validate and start download
@@ -57,6 +68,43 @@ func handleKeyPress(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 					return m, nil
 				}
 
+				// The checksum is optional, but must be well formed if given; "auto" looks it up
+				if m.InputChecksum != "" && m.InputChecksum != "auto" {
+					if _, _, err := downloader.ParseChecksum(m.InputChecksum); err != nil {
+						m.AddDownloadMessage = "Error: " + err.Error()
+						m.AddDownloadSuccess = false
+						m.URLInputField = 1
+						return m, nil
+					}
+				}
+
+				// Custom headers must parse, and a cookie file must exist
+				if m.InputHeaders != "" {
+					if _, err := downloader.ParseHeaders(m.InputHeaders); err != nil {
+						m.AddDownloadMessage = "Error: " + err.Error()
+						m.AddDownloadSuccess = false
+						m.URLInputField = 3
+						return m, nil
+					}
+				}
+				if m.InputCookieFile != "" {
+					if _, err := os.Stat(m.InputCookieFile); err != nil {
+						m.AddDownloadMessage = "Error: cannot read cookie file: " + err.Error()
+						m.AddDownloadSuccess = false
+						m.URLInputField = 4
+						return m, nil
+					}
+				}
+
+				// Prerequisites must already be in the list
+				dependsOn, err := m.resolveDependencies(m.InputDependsOn)
+				if err != nil {
+					m.AddDownloadMessage = "Error: " + err.Error()
+					m.AddDownloadSuccess = false
+					m.URLInputField = 5
+					return m, nil
+				}
+
 				// Check if the queue has capacity
 				queueName := m.InputQueue
 				var queue *config.QueueConfig
@@ -83,21 +131,29 @@ func handleKeyPress(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 						return m, nil
 					}
 
-					// Store URL before clearing it
+					// Store URL and options before clearing them
 					url := m.InputURL
+					opts := DownloadOptions{
+						Checksum:   m.InputChecksum,
+						UserAgent:  m.InputUserAgent,
+						Headers:    m.InputHeaders,
+						CookieFile: m.InputCookieFile,
+						DependsOn:  dependsOn,
+					}
 
 					// All checks passed, start the download
 					cmd := func() tea.Msg {
 						return StartDownloadMsg{
-							URL:   url,
-							Queue: m.InputQueue,
+							URL:     url,
+							Queue:   m.InputQueue,
+							Options: opts,
 						}
 					}
 
 					m.AddDownloadMessage = fmt.Sprintf("Success: Download started in queue '%s'", queueName)
 					m.AddDownloadSuccess = true
 					m.URLInputMode = false
-					m.InputURL = ""
+					m.clearURLInput()
 
 					return m, cmd
 				} else {
@@ -110,14 +166,16 @@ func handleKeyPress(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			return m, nil
 		case tea.KeyBackspace:
 			// Handle backspace
-			if len(m.InputURL) > 0 {
-				m.InputURL = m.InputURL[:len(m.InputURL)-1]
+			field := m.urlInputFields()[m.URLInputField]
+			if len(*field) > 0 {
+				*field = (*field)[:len(*field)-1]
 			}
 			return m, nil
 		default:
 			// Handle all other keys as text input
 			if msg.Type == tea.KeyRunes {
-				m.InputURL += string(msg.Runes)
+				field := m.urlInputFields()[m.URLInputField]
+				*field += string(msg.Runes)
 			}
 			return m, nil
 		}
@@ -144,7 +202,7 @@ func handleKeyPress(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 				m.InputQueue = m.Config.Queues[m.QueueSelected].Name
 				m.QueueSelectionMode = false
 				m.URLInputMode = true
-				m.InputURL = ""
+				m.clearURLInput()
 			}
 		case "esc":
 			// Cancel queue selection
@@ -303,7 +361,7 @@ func handleNavigationMode(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 
 // handleStartDownload processes a new download request
 func handleStartDownload(m Model, msg StartDownloadMsg) (tea.Model, tea.Cmd) {
-	m.AddDownload(msg.URL, msg.Queue)
+	m.AddDownload(msg.URL, msg.Queue, msg.Options)
 
 	// Custom command to help with UI refresh after adding a download
 	var cmd tea.Cmd = func() tea.Msg {
@@ -397,6 +455,26 @@ func handleDownloadListTab(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		if m.Selected < len(m.Downloads)-1 {
 			m.Selected++
 		}
+	case "K", "shift+up":
+		// Move the selected download earlier in its queue
+		m.MoveSelected(-1)
+	case "J", "shift+down":
+		// Move the selected download later in its queue
+		m.MoveSelected(1)
+	case "m":
+		// Move the selected download to the next queue
+		m.MoveSelectedToNextQueue()
+	case "w":
+		// Wait for the download above to complete first
+		m.ToggleWaitForAbove()
+	case "+", "=":
+		m.ChangePriority(1)
+	case "-":
+		m.ChangePriority(-1)
+	case "[":
+		m.ChangeBandwidth(-1)
+	case "]":
+		m.ChangeBandwidth(1)
 	case "p":
 		m.PauseDownload()
 	case "s":
@@ -406,16 +484,25 @@ func handleDownloadListTab(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	case "r":
 		// Retry the selected download if it's in error state
 		m.RetryDownload()
+	case "o":
+		// Overwrite the existing file of a conflicting download
+		m.ResolveConflict(downloader.CollisionOverwrite)
+	case "n":
+		// Save a conflicting download under a new name
+		m.ResolveConflict(downloader.CollisionRename)
+	case "x":
+		// Skip a conflicting download if the existing file is identical
+		m.ResolveConflict(downloader.CollisionSkip)
 	case "a":
 		// Switch to Add Download tab
 		m.ActiveTab = AddDownloadTab
 		m.Menu = "add"
 	case "d":
 		// Delete the selected download
-		if m.Selected >= 0 && m.Selected < len(m.Downloads) {
-			selectedDownload := m.Downloads[m.Selected]
+		if i := m.selectedIndex(); i >= 0 {
+			selectedDownload := m.Downloads[i]
 			m.QueueManager.RemoveDownload(selectedDownload.URL)
-			m.Downloads = append(m.Downloads[:m.Selected], m.Downloads[m.Selected+1:]...)
+			m.Downloads = append(m.Downloads[:i], m.Downloads[i+1:]...)
 			if m.Selected >= len(m.Downloads) {
 				m.Selected = len(m.Downloads) - 1
 			}
@@ -441,17 +528,21 @@ func handleQueueListTab(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 				m.QueueFormField--
 			}
 		case "down", "tab":
-			if m.QueueFormField < 5 { // 6 fields total (0-5)
+			if m.QueueFormField < 10 { // 11 fields total (0-10)
 				m.QueueFormField++
 			}
 		case "enter":
-			if m.QueueFormField < 5 {
+			if m.QueueFormField < 10 {
 				// Move to next field
 				m.QueueFormField++
 			} else {
-				// Submit form
-				m.SaveQueueForm()
-				m.QueueFormMode = false
+				// Submit form, staying in it if a value is invalid
+				if err := m.SaveQueueForm(); err != nil {
+					m.ErrorMessage = fmt.Sprintf("Error saving queue: %v", err)
+				} else {
+					m.ErrorMessage = ""
+					m.QueueFormMode = false
+				}
 			}
 		default:
 			// Handle text input
@@ -481,6 +572,11 @@ func handleQueueListTab(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		m.InputQueueSpeedLimit = "0"
 		m.InputQueueStartTime = "00:00"
 		m.InputQueueEndTime = "23:59"
+		m.InputQueueSegments = "1"
+		m.InputQueueSchedule = ""
+		m.InputQueueWindows = ""
+		m.InputQueueDaily = "0"
+		m.InputQueueMonthly = "0"
 	case "e":
 		// Edit queue
 		if m.QueueSelected >= 0 && m.QueueSelected < len(m.Config.Queues) {
@@ -493,7 +589,28 @@ func handleQueueListTab(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			m.InputQueueSpeedLimit = fmt.Sprintf("%d", q.SpeedLimit)
 			m.InputQueueStartTime = q.StartTime
 			m.InputQueueEndTime = q.EndTime
+			m.InputQueueSegments = fmt.Sprintf("%d", q.SegmentCount)
+			m.InputQueueSchedule = config.FormatBandwidthSchedule(q.BandwidthSchedule)
+			m.InputQueueWindows = config.FormatScheduleWindows(q.Windows)
+			m.InputQueueDaily = config.FormatByteSize(q.DailyQuota)
+			m.InputQueueMonthly = config.FormatByteSize(q.MonthlyQuota)
 		}
+	case "p":
+		// Pause every download in the queue
+		m.PauseSelectedQueue()
+	case "r":
+		// Resume the queue's downloads
+		m.ResumeSelectedQueue()
+	case "x":
+		// Finish active downloads, start no new ones
+		m.DrainSelectedQueue()
+	case " ":
+		// Enable or disable the queue
+		m.ToggleSelectedQueue()
+	case "esc":
+		// Clear any messages
+		m.QueueListMessage = ""
+		m.QueueListSuccess = false
 	case "d":
 		// Delete queue
 		if m.QueueSelected >= 0 && m.QueueSelected < len(m.Config.Queues) {
@@ -525,11 +642,11 @@ func handleQueueFormInput(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 			m.QueueFormField--
 		}
 	case "down", "tab":
-		if m.QueueFormField < 5 { // 6 fields total (0-5)
+		if m.QueueFormField < 10 { // 11 fields total (0-10)
 			m.QueueFormField++
 		}
 	case "enter":
-		if m.QueueFormField < 5 {
+		if m.QueueFormField < 10 {
 			// Move to next field
 			m.QueueFormField++
 		} else {
@@ -550,6 +667,11 @@ func handleQueueFormInput(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		m.InputQueueSpeedLimit = ""
 		m.InputQueueStartTime = ""
 		m.InputQueueEndTime = ""
+		m.InputQueueSegments = ""
+		m.InputQueueSchedule = ""
+		m.InputQueueWindows = ""
+		m.InputQueueDaily = ""
+		m.InputQueueMonthly = ""
 		m.QueueFormField = 0
 	default:
 		// Handle text input based on current field
@@ -579,6 +701,26 @@ func handleQueueFormInput(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 				if len(m.InputQueueEndTime) > 0 {
 					m.InputQueueEndTime = m.InputQueueEndTime[:len(m.InputQueueEndTime)-1]
 				}
+			case 6:
+				if len(m.InputQueueSegments) > 0 {
+					m.InputQueueSegments = m.InputQueueSegments[:len(m.InputQueueSegments)-1]
+				}
+			case 7:
+				if len(m.InputQueueSchedule) > 0 {
+					m.InputQueueSchedule = m.InputQueueSchedule[:len(m.InputQueueSchedule)-1]
+				}
+			case 8:
+				if len(m.InputQueueWindows) > 0 {
+					m.InputQueueWindows = m.InputQueueWindows[:len(m.InputQueueWindows)-1]
+				}
+			case 9:
+				if len(m.InputQueueDaily) > 0 {
+					m.InputQueueDaily = m.InputQueueDaily[:len(m.InputQueueDaily)-1]
+				}
+			case 10:
+				if len(m.InputQueueMonthly) > 0 {
+					m.InputQueueMonthly = m.InputQueueMonthly[:len(m.InputQueueMonthly)-1]
+				}
 			}
 		} else if msg.Type == tea.KeyRunes {
 			switch m.QueueFormField {
@@ -594,6 +736,16 @@ func handleQueueFormInput(m Model, msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 				m.InputQueueStartTime += string(msg.Runes)
 			case 5:
 				m.InputQueueEndTime += string(msg.Runes)
+			case 6:
+				m.InputQueueSegments += string(msg.Runes)
+			case 7:
+				m.InputQueueSchedule += string(msg.Runes)
+			case 8:
+				m.InputQueueWindows += string(msg.Runes)
+			case 9:
+				m.InputQueueDaily += string(msg.Runes)
+			case 10:
+				m.InputQueueMonthly += string(msg.Runes)
 			}
 		}
 	}
@@ -2,9 +2,13 @@ package tui
 
 import (
 	"fmt"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/lipgloss"
+	"github.com/mahdiXak47/Download-Manager/internal/auth"
+	"github.com/mahdiXak47/Download-Manager/internal/downloader"
 )
 
 func (m Model) View() string {
@@ -138,13 +142,30 @@ func renderAddDownloadTab(m Model) string {
 		s.WriteString(centerContainer.Render(menuItemStyle.Render("Selected Queue: " + urlStyle.Render(m.InputQueue))))
 		s.WriteString("\n\n")
 
-		// URL input field
-		s.WriteString(centerContainer.Render(inputBoxStyle.Render(
-			menuItemStyle.Render("URL: " + urlStyle.Render(m.InputURL+"_")),
-		)))
+		// URL and optional settings, the cursor marks the active field
+		labels := []string{
+			"URL",
+			"Checksum (optional, sha256:<hex> or auto)",
+			"User-Agent (optional)",
+			"Headers (optional, Name: value | Other: value)",
+			"Cookie file (optional, cookies.txt)",
+			"After (optional, list numbers or URLs to finish first)",
+		}
+		for i, field := range m.urlInputFields() {
+			cursor := ""
+			if i == m.URLInputField {
+				cursor = "_"
+			}
+			if i > 0 {
+				s.WriteString("\n")
+			}
+			s.WriteString(centerContainer.Render(inputBoxStyle.Render(
+				menuItemStyle.Render(labels[i] + ": " + urlStyle.Render(*field+cursor)),
+			)))
+		}
 
 		// Help text for input mode
-		s.WriteString("\n\n" + helpStyle.Width(m.Width).Render("[ Enter ] Start Download   [ Esc ] Back"))
+		s.WriteString("\n\n" + helpStyle.Width(m.Width).Render("[ Tab ] Switch Field   [ Enter ] Start Download   [ Esc ] Back"))
 	} else {
 		// Initial instructions
 		s.WriteString(centerContainer.Render(menuItemStyle.Render("Press Enter to add a new download")))
@@ -187,6 +208,7 @@ func renderDownloadListTab(m Model) string {
 		}{
 			{"Path", 30},
 			{"#", 5},
+			{"Pri", 5},
 			{"Status", 15},
 			{"Queue", 15},
 			{"Progress", 10},
@@ -204,10 +226,12 @@ func renderDownloadListTab(m Model) string {
 
 		// Create table rows for each download
 		var rows []string
-		for i, d := range m.Downloads {
+		for row, index := range m.downloadOrder() {
+			d := &m.Downloads[index]
+
 			// Choose row style based on selection
 			rowStyle := normalRowStyle.Copy()
-			if i == m.Selected {
+			if row == m.Selected {
 				rowStyle = selectedRowStyle.Copy()
 			}
 
@@ -226,7 +250,8 @@ func renderDownloadListTab(m Model) string {
 				width   int
 			}{
 				{d.TargetPath, 30},
-				{fmt.Sprintf("%d", i+1), 5},
+				{fmt.Sprintf("%d", row+1), 5},
+				{fmt.Sprintf("%+d", d.Priority), 5},
 				{d.Status, 15},
 				{d.Queue, 15},
 				{progress, 10},
@@ -261,10 +286,28 @@ func renderDownloadListTab(m Model) string {
 			),
 		)
 		s.WriteString(centerContainer.Render(table))
+
+		// Checksum and conflict details of the selected download
+		if i := m.selectedIndex(); i >= 0 {
+			selected := &m.Downloads[i]
+			if details := renderChecksumDetails(selected); details != "" {
+				s.WriteString("\n" + centerContainer.Render(menuItemStyle.Render(details)))
+			}
+			if details := renderAttemptDetails(selected); details != "" {
+				s.WriteString("\n" + centerContainer.Render(menuItemStyle.Render(details)))
+			}
+			if details := renderDependencyDetails(m, selected); details != "" {
+				s.WriteString("\n" + centerContainer.Render(menuItemStyle.Render(details)))
+			}
+			if selected.Status == "conflict" {
+				s.WriteString("\n" + centerContainer.Render(menuItemStyle.Render(
+					selected.TargetPath+" already exists:   [ o ] Overwrite   [ n ] Keep Both   [ x ] Skip If Identical")))
+			}
+		}
 	}
 
 	// Help text
-	s.WriteString("\n" + helpStyle.Width(m.Width).Render("[ ↑/↓ ] Navigate   [ p ] Pause   [ r ] Resume   [ d ] Delete   [ y ] Retry"))
+	s.WriteString("\n" + helpStyle.Width(m.Width).Render("[ ↑/↓ ] Navigate   [ K/J ] Move Up/Down   [ +/- ] Priority   [ [/] ] Speed Limit   [ m ] Move Queue   [ w ] Wait For Above   [ p ] Pause   [ r ] Resume   [ d ] Delete   [ y ] Retry"))
 
 	return s.String()
 }
@@ -278,6 +321,20 @@ func renderQueueListTab(m Model) string {
 	s.WriteString(centerContainer.Render(menuHeaderStyle.Render("Queue Management")))
 	s.WriteString("\n\n")
 
+	if m.QueueListMessage != "" && !m.QueueFormMode {
+		msgStyle := errorStyle
+		if m.QueueListSuccess {
+			msgStyle = msgStyle.Copy().
+				Foreground(lipgloss.Color(CurrentTheme.Special.Dark)).
+				BorderForeground(lipgloss.Color(CurrentTheme.Special.Dark))
+		} else {
+			msgStyle = msgStyle.Copy().
+				Foreground(lipgloss.Color(CurrentTheme.Error.Dark)).
+				BorderForeground(lipgloss.Color(CurrentTheme.Error.Dark))
+		}
+		s.WriteString(centerContainer.Render(msgStyle.Render(m.QueueListMessage)) + "\n\n")
+	}
+
 	if m.QueueFormMode {
 		// Queue form
 		formContent := strings.Builder{}
@@ -292,6 +349,11 @@ func renderQueueListTab(m Model) string {
 			"Speed Limit",
 			"Start Time",
 			"End Time",
+			"Segments",
+			"Speed Schedule",
+			"Windows",
+			"Daily Quota",
+			"Monthly Quota",
 		}
 		values := []string{
 			m.InputQueueName,
@@ -300,6 +362,11 @@ func renderQueueListTab(m Model) string {
 			m.InputQueueSpeedLimit + " KB/s (0 = unlimited)",
 			m.InputQueueStartTime + " (format: HH:MM)",
 			m.InputQueueEndTime + " (format: HH:MM)",
+			m.InputQueueSegments + " connections per download",
+			m.InputQueueSchedule + " (HH:MM-HH:MM=KB/s, ...)",
+			m.InputQueueWindows + " ([mon..sun|weekdays|weekends] HH:MM-HH:MM; ...)",
+			m.InputQueueDaily + " per day, e.g. 500M or 2G (0 = none)",
+			m.InputQueueMonthly + " per month (0 = none)",
 		}
 
 		// Find the longest label for alignment
@@ -348,11 +415,13 @@ func renderQueueListTab(m Model) string {
 			tableWidth := m.Width - 24 // Account for margins, padding, and borders
 
 			// Define proportional column widths
-			nameWidth := tableWidth / 4     // 25%
-			pathWidth := tableWidth * 2 / 5 // 40%
-			concWidth := tableWidth / 8     // 12.5%
-			speedWidth := tableWidth / 8    // 12.5%
-			activeWidth := tableWidth / 10  // 10%
+			nameWidth := tableWidth / 5         // 20%
+			pathWidth := tableWidth * 11 / 50   // 22%
+			concWidth := tableWidth / 12        // 8.3%
+			speedWidth := tableWidth / 10       // 10%
+			activeWidth := tableWidth / 10      // 10%
+			nextWidth := tableWidth / 6         // 16.7%
+			stateWidth := tableWidth * 13 / 100 // 13%
 
 			// Create table container style
 			tableContainer := tableStyle.Copy().
@@ -376,6 +445,8 @@ func renderQueueListTab(m Model) string {
 				{"Max", concWidth},
 				{"Speed", speedWidth},
 				{"Active", activeWidth},
+				{"Next Start", nextWidth},
+				{"State", stateWidth},
 			}
 
 			// Build header row
@@ -402,10 +473,38 @@ func renderQueueListTab(m Model) string {
 					rowStyle = selectedRowStyle.Copy()
 				}
 
-				// Format speed limit
+				// Format the speed limit in effect now, following the bandwidth schedule
 				speedLimit := "∞"
-				if q.SpeedLimit > 0 {
-					speedLimit = fmt.Sprintf("%dK", q.SpeedLimit)
+				if limit := q.EffectiveSpeedLimit(time.Now()); limit > 0 {
+					speedLimit = fmt.Sprintf("%dK", limit)
+				}
+
+				// When the schedule next lets the queue run
+				nextStart := "never"
+				if !q.Enabled {
+					nextStart = "disabled"
+				} else {
+					// A used up quota holds the queue until it resets
+					from := time.Now()
+					if reset, ok := q.QuotaResetAt(from); ok {
+						from = reset
+					}
+					if next, ok := q.NextAllowedStart(from); ok {
+						nextStart = formatNextStart(next)
+					}
+				}
+
+				// Whether the queue is running, held or switched off
+				state := "enabled"
+				switch {
+				case !q.Enabled:
+					state = "disabled"
+				case q.Paused:
+					state = "paused"
+				case q.Draining:
+					state = "draining"
+				case q.QuotaExceeded(time.Now()) != "":
+					state = "over quota"
 				}
 
 				// Create row cells
@@ -418,6 +517,8 @@ func renderQueueListTab(m Model) string {
 					{fmt.Sprintf("%d", q.MaxConcurrent), concWidth},
 					{speedLimit, speedWidth},
 					{fmt.Sprintf("%d/%d", activeCount, q.MaxConcurrent), activeWidth},
+					{nextStart, nextWidth},
+					{state, stateWidth},
 				}
 
 				// Build row with cells
@@ -442,7 +543,7 @@ func renderQueueListTab(m Model) string {
 	}
 
 	// Help text
-	s.WriteString("\n\n" + helpStyle.Width(m.Width).Render("[ ↑/↓ ] Navigate   [ n ] New   [ e ] Edit   [ d ] Delete"))
+	s.WriteString("\n\n" + helpStyle.Width(m.Width).Render("[ ↑/↓ ] Navigate   [ n ] New   [ e ] Edit   [ d ] Delete   [ p ] Pause All   [ r ] Resume All   [ x ] Drain   [ space ] Enable/Disable"))
 
 	return s.String()
 }
@@ -464,6 +565,17 @@ func renderSettingsTab(m Model) string {
 	s.WriteString(centerStyle.Render("Current Theme: " + m.CurrentTheme))
 	s.WriteString("\n" + centerStyle.Render("Press 't' to cycle through available themes"))
 
+	// Credentials are kept out of the shareable config file
+	s.WriteString("\n\n" + menuHeaderStyle.Copy().Width(m.Width-8).Align(lipgloss.Center).Render("Credentials"))
+	s.WriteString("\n")
+	s.WriteString(centerStyle.Render(fmt.Sprintf("%d stored in %s", auth.Count(), auth.GetCredentialsPath())))
+
+	// Caps shared by all queues, set in the config file
+	s.WriteString("\n\n" + menuHeaderStyle.Copy().Width(m.Width-8).Align(lipgloss.Center).Render("Concurrency"))
+	s.WriteString("\n")
+	s.WriteString(centerStyle.Render(fmt.Sprintf("Downloads across all queues: %s   Connections per host: %s",
+		formatCap(m.Config.MaxConcurrentDownloads), formatCap(m.Config.MaxConnectionsPerHost))))
+
 	// Keyboard shortcuts
 	s.WriteString("\n\n" + menuHeaderStyle.Copy().Width(m.Width-8).Align(lipgloss.Center).Render("Keyboard Shortcuts"))
 	s.WriteString("\n")
@@ -487,9 +599,17 @@ func renderSettingsTab(m Model) string {
 		"p:               Pause download",
 		"r:               Resume download",
 		"c:               Cancel download",
+		"K/J:             Move download up/down",
+		"+/-:             Raise/lower priority",
+		"[/]:             Lower/raise download speed limit",
+		"m:               Move download to next queue",
+		"w:               Wait for the download above",
 		"n:               New queue",
 		"e:               Edit queue",
 		"d:               Delete queue",
+		"p/r (queues):    Pause/resume all in queue",
+		"x:               Drain queue",
+		"Space:           Enable/disable queue",
 		"t:               Change theme",
 		"q:               Quit application",
 	}
@@ -507,6 +627,91 @@ func renderSettingsTab(m Model) string {
 	return s.String()
 }
 
+// renderDependencyDetails lists the downloads the selected one waits for by their row
+// numbers, and why it is blocked if it is
+func renderDependencyDetails(m Model, d *downloader.Download) string {
+	if len(d.DependsOn) == 0 {
+		return ""
+	}
+
+	rows := make(map[string]int)
+	for row, index := range m.downloadOrder() {
+		rows[m.Downloads[index].URL] = row + 1
+	}
+	var after []string
+	for _, url := range d.DependsOn {
+		if row, ok := rows[url]; ok {
+			after = append(after, fmt.Sprintf("#%d", row))
+		}
+	}
+	if len(after) == 0 {
+		return ""
+	}
+
+	details := "After: " + strings.Join(after, ", ")
+	if d.Status == "blocked" {
+		details += "   Blocked: " + d.Error
+	}
+	return details
+}
+
+// formatCap formats a concurrency cap, where 0 means no cap
+func formatCap(limit int) string {
+	if limit <= 0 {
+		return "∞"
+	}
+	return fmt.Sprintf("%d", limit)
+}
+
+// formatNextStart describes when a queue may next run: "now", a time today, or a day and time
+func formatNextStart(next time.Time) string {
+	now := time.Now()
+	switch {
+	case !next.After(now):
+		return "now"
+	case next.YearDay() == now.YearDay() && next.Year() == now.Year():
+		return next.Format("15:04")
+	case next.Sub(now) < 7*24*time.Hour:
+		return next.Format("Mon 15:04")
+	default:
+		return next.Format("Jan 2 15:04")
+	}
+}
+
+// renderChecksumDetails describes the expected and computed checksum of a download
+func renderChecksumDetails(d *downloader.Download) string {
+	if d.ChecksumAlgorithm == "" {
+		if d.FetchChecksum {
+			return "Checksum: looked up from published checksum files"
+		}
+		return ""
+	}
+
+	algorithm := strings.ToUpper(d.ChecksumAlgorithm)
+	switch {
+	case d.ComputedChecksum == "":
+		return fmt.Sprintf("%s expected: %s", algorithm, d.ExpectedChecksum)
+	case d.Status == "verify-failed":
+		return fmt.Sprintf("%s mismatch: computed %s, expected %s; file kept as %s",
+			algorithm, d.ComputedChecksum, d.ExpectedChecksum, filepath.Base(d.CorruptPath()))
+	default:
+		return fmt.Sprintf("%s verified: %s", algorithm, d.ComputedChecksum)
+	}
+}
+
+// renderAttemptDetails describes the last failed attempt of a download that is in error
+func renderAttemptDetails(d *downloader.Download) string {
+	if d.Status != "error" || len(d.Attempts) == 0 {
+		return ""
+	}
+	last := d.Attempts[len(d.Attempts)-1]
+	kind := "will not be retried automatically"
+	if last.Retryable {
+		kind = "retries used up"
+	}
+	return fmt.Sprintf("Failed at %s (%s, %s): %s", last.Time.Format("15:04:05"), last.Cause, kind, last.Error)
+}
+
 // Helper function to truncate long strings
 func truncateString(s string, maxLen int) string {
 	if len(s) <= maxLen {